@@ -58,6 +58,8 @@ var cameraMatrix = [6]lookAtCamera{
 var loadedTree struct {
 	maxDepth int
 	tree     trace.Octree
+	position trace.Vec3
+	scale    float32
 }
 
 type (
@@ -113,16 +115,34 @@ func loadTree(file string) error {
 	defer treeFp.Close()
 
 	log.Println("loading octree:", file)
-	tree, vpa, err := trace.LoadOctree(treeFp)
+	tree, vpa, bounds, err := trace.MapOctree(treeFp)
 	if err != nil {
 		return err
 	}
 
+	// Release the mapping of a replaced tree, it must no longer be rendered.
+	if loadedTree.tree != nil {
+		trace.UnmapOctree(loadedTree.tree)
+	}
+
 	loadedTree.maxDepth = trace.TreeWidthToDepth(vpa)
 	loadedTree.tree = tree
+	loadedTree.scale = 1
+
+	if bounds.Size > 0 {
+		loadedTree.position = trace.Vec3{float32(bounds.Pos.X), float32(bounds.Pos.Y), float32(bounds.Pos.Z)}
+		loadedTree.scale = float32(bounds.Size)
+	}
 	return nil
 }
 
+// treePosition maps a camera position given in tree units, where the tree
+// spans 0 to 1 on each axis, to the placement from the bounds of the tree.
+func treePosition(pos [3]float32) trace.Vec3 {
+	p, s := loadedTree.position, loadedTree.scale
+	return trace.Vec3{p[0] + pos[0]*s, p[1] + pos[1]*s, p[2] + pos[2]*s}
+}
+
 func renderServer(ws *websocket.Conn) {
 	addr := ws.RemoteAddr()
 	log.Println("new connection:", addr)
@@ -165,7 +185,8 @@ func renderServer(ws *websocket.Conn) {
 
 		cfg := trace.Config{
 			FieldOfView:   1.55,
-			TreeScale:     1,
+			TreeScale:     loadedTree.scale,
+			TreePosition:  loadedTree.position,
 			ViewDist:      float32(arguments.viewDistance),
 			Images:        [2]*image.RGBA{surfaces[i], nil},
 			Jitter:        false,
@@ -198,7 +219,7 @@ func renderServer(ws *websocket.Conn) {
 		update := <-updateChan
 		for i := 0; i < 6; i++ {
 			camera := cameras[i]
-			camera.pos = update.Position
+			camera.pos = treePosition(update.Position)
 			camera.at[0] += camera.pos[0]
 			camera.at[1] += camera.pos[1]
 			camera.at[2] += camera.pos[2]
@@ -108,6 +108,17 @@ func newOctree(file string) (uint32, []uint32, error) {
 	if header.Format != pack.MipR8G8B8A8UnpackUI32 {
 		return 0, nil, errors.New("invalid octree format")
 	}
+
+	if header.Channels != 0 {
+		return 0, nil, errors.New("attribute channels are not supported")
+	}
+
+	nodeReader, err := pack.NewNodeReader(fp, &header)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer nodeReader.Close()
+
 	nodeSize := uint64(1 + 8)
 
 	var maxSize int32
@@ -136,7 +147,12 @@ func newOctree(file string) (uint32, []uint32, error) {
 
 	for i := uint64(0); i < header.NumNodes; i++ {
 		start := i * nodeSize
-		if err := binary.Read(fp, binary.LittleEndian, data[start:start+nodeSize]); err != nil {
+		// The color is stored as bytes and is not affected by the byte order.
+		if err := binary.Read(nodeReader, binary.LittleEndian, &data[start]); err != nil {
+			return 0, nil, err
+		}
+
+		if err := binary.Read(nodeReader, header.ByteOrder(), data[start+1:start+nodeSize]); err != nil {
 			return 0, nil, err
 		}
 
@@ -0,0 +1,477 @@
+/*
+Copyright (C) 2015-2016 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/andreas-jonsson/octatron/pack"
+)
+
+type command struct {
+	usage string
+	run   func(args []string)
+}
+
+var commands = map[string]command{
+	"validate": {"check that octree files are well formed", validateCommand},
+	"meta":     {"print or update the metadata of octree files", metaCommand},
+	"merge":    {"combine octree files into one tree", mergeCommand},
+	"crop":     {"extract the part of a tree inside a box", cropCommand},
+	"lod":      {"drop the deepest levels of a tree", lodCommand},
+	"export":   {"write the leafs of a tree as a point cloud", exportCommand},
+	"mesh":     {"write the voxel surface of a tree as a triangle mesh", meshCommand},
+	"vox":      {"convert between MagicaVoxel .vox and octree files", voxCommand},
+}
+
+func printCommands() {
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Printf("Commands:\n")
+	for _, name := range names {
+		fmt.Printf("  %v\t%v\n", name, commands[name].usage)
+	}
+	fmt.Println()
+}
+
+func runCommand() bool {
+	if len(os.Args) < 2 {
+		return false
+	}
+
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		return false
+	}
+
+	cmd.run(os.Args[2:])
+	return true
+}
+
+func printNodes(name string, nodes []uint64) {
+	const maxNodes = 16
+	if len(nodes) == 0 {
+		return
+	}
+
+	if len(nodes) > maxNodes {
+		fmt.Printf("  %v: %v ... (%v nodes)\n", name, nodes[:maxNodes], len(nodes))
+	} else {
+		fmt.Printf("  %v: %v\n", name, nodes)
+	}
+}
+
+func validateCommand(args []string) {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Printf("Usage: packer validate [tree.oct ...]\n")
+	}
+	flags.Parse(args)
+
+	valid := true
+	for _, file := range flags.Args() {
+		fp, err := os.Open(file)
+		assert(err)
+
+		report, err := pack.Validate(fp)
+		fp.Close()
+		assert(err)
+
+		if report.Valid() {
+			fmt.Printf("%v: ok (%v nodes, %v leafs)\n", file, report.Header.NumNodes, report.NumLeafs)
+			continue
+		}
+
+		valid = false
+		fmt.Printf("%v: invalid\n", file)
+		for _, err := range report.Errors {
+			fmt.Printf("  %v\n", err)
+		}
+
+		printNodes("child index out of range", report.OutOfRange)
+		printNodes("cycles", report.Cycles)
+		printNodes("shared children", report.Shared)
+		printNodes("unreachable", report.Unreachable)
+	}
+
+	if !valid {
+		os.Exit(-1)
+	}
+}
+
+func metaCommand(args []string) {
+	var set string
+
+	flags := flag.NewFlagSet("meta", flag.ExitOnError)
+	flags.StringVar(&set, "set", "", "metadata to add \"key=value,...\", an empty value removes the key")
+	flags.Usage = func() {
+		fmt.Printf("Usage: packer meta [options] [tree.oct ...]\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	for _, file := range flags.Args() {
+		fp, err := os.Open(file)
+		assert(err)
+
+		meta, err := pack.ReadMetadata(fp)
+		assert(err)
+
+		if set != "" {
+			assert(parseMetadata(set, meta))
+			for key, value := range meta {
+				if value == "" {
+					delete(meta, key)
+				}
+			}
+
+			_, err = fp.Seek(0, 0)
+			assert(err)
+
+			info, err := fp.Stat()
+			assert(err)
+
+			outfile, err := ioutil.TempFile(path.Dir(file), "")
+			assert(err)
+
+			assert(outfile.Chmod(info.Mode()))
+			assert(pack.WriteMetadata(fp, outfile, meta))
+			outfile.Close()
+			fp.Close()
+			assert(os.Rename(outfile.Name(), file))
+		} else {
+			fp.Close()
+		}
+
+		var keys []string
+		for key := range meta {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		fmt.Printf("%v:\n", file)
+		for _, key := range keys {
+			fmt.Printf("  %v: %v\n", key, meta[key])
+		}
+	}
+}
+
+func mergeCommand(args []string) {
+	var (
+		format, output, bounds string
+		vpa                    int
+		threshold              float64
+		optimize, filter       bool
+		bigEndian              bool
+	)
+
+	flags := flag.NewFlagSet("merge", flag.ExitOnError)
+	flags.StringVar(&format, "format", "MipR8G8B8A8PackUI28", "octree packing format")
+	flags.StringVar(&output, "output", "merged.oct", "")
+	flags.StringVar(&bounds, "bounds", "", "bounding-box of each tree \"X,Y,Z,SIZE;...\", empty entries use the bounds in the tree")
+	flags.IntVar(&vpa, "vpa", 0, "voxels per axis, 0 keeps the voxel size of the most detailed tree")
+	flags.Float64Var(&threshold, "threshold", 0.25, "color-filter threshold")
+	flags.BoolVar(&optimize, "optimize", true, "optimize tree")
+	flags.BoolVar(&filter, "filter", true, "apply color-filter")
+	flags.BoolVar(&bigEndian, "bigendian", false, "write big-endian tree")
+	flags.Usage = func() {
+		fmt.Printf("Usage: packer merge [options] tree.oct tree.oct ...\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	outputFormat, ok := formatLookup[format]
+	if !ok {
+		assert(fmt.Errorf("unknown format: %v", format))
+	}
+
+	cfg := pack.MergeConfig{
+		Format:         outputFormat,
+		VoxelsPerAxis:  vpa,
+		Optimize:       optimize,
+		ColorFilter:    filter,
+		ColorThreshold: float32(threshold),
+	}
+
+	if bigEndian {
+		cfg.ByteOrder = binary.BigEndian
+	}
+
+	for _, file := range flags.Args() {
+		fp, err := os.Open(file)
+		assert(err)
+		defer fp.Close()
+		cfg.Trees = append(cfg.Trees, fp)
+	}
+
+	if bounds != "" {
+		for _, box := range strings.Split(bounds, ";") {
+			var b pack.Box
+			if box != "" {
+				_, err := fmt.Sscanf(box, "%f,%f,%f,%f", &b.Pos.X, &b.Pos.Y, &b.Pos.Z, &b.Size)
+				assert(err)
+			}
+			cfg.Bounds = append(cfg.Bounds, b)
+		}
+	}
+
+	outfile, err := os.Create(output)
+	assert(err)
+	defer outfile.Close()
+
+	cfg.Writer = outfile
+	status, err := pack.MergeTrees(&cfg)
+	assert(err)
+	fmt.Println("Status:", status)
+}
+
+func cropCommand(args []string) {
+	var (
+		box, output string
+		min, max    pack.Point
+	)
+
+	flags := flag.NewFlagSet("crop", flag.ExitOnError)
+	flags.StringVar(&box, "box", "", "world-space box MINX,MINY,MINZ,MAXX,MAXY,MAXZ")
+	flags.StringVar(&output, "output", "crop.oct", "")
+	flags.Usage = func() {
+		fmt.Printf("Usage: packer crop [options] tree.oct\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(-1)
+	}
+
+	_, err := fmt.Sscanf(box, "%f,%f,%f,%f,%f,%f", &min.X, &min.Y, &min.Z, &max.X, &max.Y, &max.Z)
+	assert(err)
+
+	fp, err := os.Open(flags.Arg(0))
+	assert(err)
+	defer fp.Close()
+
+	outfile, err := os.Create(output)
+	assert(err)
+	defer outfile.Close()
+
+	assert(pack.CropTree(fp, outfile, min, max))
+}
+
+func lodCommand(args []string) {
+	var (
+		output string
+		vpa    int
+	)
+
+	flags := flag.NewFlagSet("lod", flag.ExitOnError)
+	flags.StringVar(&output, "output", "lod.oct", "")
+	flags.IntVar(&vpa, "vpa", 64, "voxels per axis of the new tree")
+	flags.Usage = func() {
+		fmt.Printf("Usage: packer lod [options] tree.oct\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(-1)
+	}
+
+	fp, err := os.Open(flags.Arg(0))
+	assert(err)
+	defer fp.Close()
+
+	outfile, err := os.Create(output)
+	assert(err)
+	defer outfile.Close()
+
+	status, err := pack.TruncateTree(fp, outfile, vpa)
+	assert(err)
+
+	fmt.Printf("Nodes: %v -> %v\n", status.InputNodes, status.OutputNodes)
+	fmt.Printf("Size: %v -> %v bytes", status.InputSize, status.OutputSize)
+	if status.InputSize > 0 {
+		fmt.Printf(", %v bytes saved (%.1f%%)", status.Saved(), 100*float64(status.Saved())/float64(status.InputSize))
+	}
+	fmt.Println()
+}
+
+func exportCommand(args []string) {
+	var (
+		output string
+		depth  int
+		ascii  bool
+	)
+
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	flags.StringVar(&output, "output", "cloud.xyz", "point cloud file, .xyz, .ply or .pcd")
+	flags.IntVar(&depth, "depth", -1, "export the nodes at this depth, -1 exports the leafs")
+	flags.BoolVar(&ascii, "ascii", false, "write ascii instead of binary ply or pcd")
+	flags.Usage = func() {
+		fmt.Printf("Usage: packer export [options] tree.oct\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(-1)
+	}
+
+	var format pack.PointFormat
+	switch strings.ToLower(path.Ext(output)) {
+	case ".xyz":
+		format = pack.PointsXYZ
+	case ".ply":
+		format = pack.PointsPLYBinary
+		if ascii {
+			format = pack.PointsPLYASCII
+		}
+	case ".pcd":
+		format = pack.PointsPCDBinary
+		if ascii {
+			format = pack.PointsPCDASCII
+		}
+	default:
+		assert(fmt.Errorf("unknown point cloud format: %v", output))
+	}
+
+	fp, err := os.Open(flags.Arg(0))
+	assert(err)
+	defer fp.Close()
+
+	outfile, err := os.Create(output)
+	assert(err)
+	defer outfile.Close()
+
+	n, err := pack.ExportPoints(fp, outfile, format, depth)
+	assert(err)
+	fmt.Printf("Points: %v\n", n)
+}
+
+func meshCommand(args []string) {
+	var (
+		output string
+		cfg    pack.MeshConfig
+	)
+
+	flags := flag.NewFlagSet("mesh", flag.ExitOnError)
+	flags.StringVar(&output, "output", "mesh.obj", "mesh file, .obj or .ply")
+	flags.IntVar(&cfg.Depth, "depth", -1, "depth of the voxels, -1 uses the deepest level")
+	flags.IntVar(&cfg.Smooth, "smooth", 0, "smoothing iterations")
+	flags.Usage = func() {
+		fmt.Printf("Usage: packer mesh [options] tree.oct\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(-1)
+	}
+
+	switch strings.ToLower(path.Ext(output)) {
+	case ".obj":
+		cfg.Format = pack.MeshOBJ
+	case ".ply":
+		cfg.Format = pack.MeshPLY
+	default:
+		assert(fmt.Errorf("unknown mesh format: %v", output))
+	}
+
+	fp, err := os.Open(flags.Arg(0))
+	assert(err)
+	defer fp.Close()
+
+	outfile, err := os.Create(output)
+	assert(err)
+	defer outfile.Close()
+
+	status, err := pack.ExportMesh(fp, outfile, &cfg)
+	assert(err)
+	fmt.Printf("Vertices: %v, faces: %v\n", status.NumVertices, status.NumFaces)
+}
+
+func voxCommand(args []string) {
+	var (
+		format, output string
+		depth          int
+	)
+
+	flags := flag.NewFlagSet("vox", flag.ExitOnError)
+	flags.StringVar(&format, "format", "MipR8G8B8A8PackUI28", "octree packing format")
+	flags.StringVar(&output, "output", "tree.oct", "output file, .oct or .vox")
+	flags.IntVar(&depth, "depth", -1, "depth of the voxels written to .vox, -1 uses the deepest level")
+	flags.Usage = func() {
+		fmt.Printf("Usage: packer vox [options] model.vox|tree.oct\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(-1)
+	}
+
+	fp, err := os.Open(flags.Arg(0))
+	assert(err)
+	defer fp.Close()
+
+	outfile, err := os.Create(output)
+	assert(err)
+	defer outfile.Close()
+
+	if strings.ToLower(path.Ext(output)) == ".vox" {
+		assert(pack.ExportVox(fp, outfile, depth))
+		return
+	}
+
+	outputFormat, ok := formatLookup[format]
+	if !ok {
+		assert(fmt.Errorf("unknown format: %v", format))
+	}
+
+	model, err := pack.ReadVox(fp)
+	assert(err)
+
+	cfg := pack.BuildConfig{
+		Worker:        model.Worker(),
+		Writer:        outfile,
+		Bounds:        model.Bounds(),
+		VoxelsPerAxis: model.VoxelsPerAxis(),
+		Format:        outputFormat,
+		Metadata:      pack.Metadata{"source": flags.Arg(0)},
+	}
+
+	status, err := pack.BuildTree(&cfg)
+	assert(err)
+	fmt.Println("Status:", status)
+}
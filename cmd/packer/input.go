@@ -0,0 +1,161 @@
+/*
+Copyright (C) 2015-2016 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/andreas-jonsson/octatron/go3d/float64/mat4"
+	"github.com/andreas-jonsson/octatron/go3d/float64/vec3"
+	"github.com/andreas-jonsson/octatron/pack"
+)
+
+// sampleReader reads all samples from an input file. Progress is reported as
+// the fraction of the file that has been read.
+type sampleReader func(infile *os.File, size int64, emit func(s *pack.Sample), progress func(fraction float64)) error
+
+// pointReader is implemented by the point cloud readers in pack.
+type pointReader interface {
+	Read(s *pack.Sample) error
+	NumPoints() uint64
+}
+
+var inputLookup = map[string]sampleReader{
+	".xyz": readXYZ,
+	".ply": readPoints(func(r io.Reader) (pointReader, error) { return pack.NewPLYReader(r) }),
+	".las": readPoints(func(r io.Reader) (pointReader, error) { return pack.NewLASReader(r) }),
+	".pcd": readPoints(func(r io.Reader) (pointReader, error) { return pack.NewPCDReader(r) }),
+}
+
+// inputReader selects a reader from the file extension, unknown extensions
+// are read as xyz.
+func inputReader(file string) sampleReader {
+	if reader, ok := inputLookup[strings.ToLower(path.Ext(file))]; ok {
+		return reader
+	}
+	return readXYZ
+}
+
+func readXYZ(infile *os.File, size int64, emit func(s *pack.Sample), progress func(fraction float64)) error {
+	var (
+		reads   int64
+		s       pack.Sample
+		r, g, b byte
+	)
+
+	scanner := bufio.NewScanner(infile)
+	for scanner.Scan() {
+		text := scanner.Text()
+
+		var ref float64
+		if arguments.reflectComponent {
+			_, err := fmt.Sscan(text, &s.Pos.X, &s.Pos.Y, &s.Pos.Z, &ref, &r, &g, &b)
+			assert(err)
+		} else {
+			_, err := fmt.Sscan(text, &s.Pos.X, &s.Pos.Y, &s.Pos.Z, &r, &g, &b)
+			assert(err)
+		}
+
+		s.Col.R = float32(r) / 255
+		s.Col.G = float32(g) / 255
+		s.Col.B = float32(b) / 255
+		s.Col.A = 1
+		s.Attributes.Intensity = float32(ref)
+
+		emit(&s)
+
+		reads += int64(len(text) + 1)
+		progress(float64(reads) / float64(size))
+	}
+	return scanner.Err()
+}
+
+// readPoints returns a sampleReader for a format with a pointReader.
+func readPoints(open func(r io.Reader) (pointReader, error)) sampleReader {
+	return func(infile *os.File, size int64, emit func(s *pack.Sample), progress func(fraction float64)) error {
+		reader, err := open(infile)
+		if err != nil {
+			return err
+		}
+
+		var s pack.Sample
+		numPoints := reader.NumPoints()
+
+		for i := uint64(1); ; i++ {
+			if err := reader.Read(&s); err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+
+			emit(&s)
+			progress(float64(i) / float64(numPoints))
+		}
+	}
+}
+
+// lasBounds returns a cube that contains the header bounds of every input
+// file after transformation.
+func lasBounds(files []string, mat *mat4.T) (pack.Box, error) {
+	min := vec3.T{math.MaxFloat64, math.MaxFloat64, math.MaxFloat64}
+	max := vec3.T{-math.MaxFloat64, -math.MaxFloat64, -math.MaxFloat64}
+
+	for _, file := range files {
+		if strings.ToLower(path.Ext(file)) != ".las" {
+			return pack.Box{}, fmt.Errorf("can not read bounds from: %v", file)
+		}
+
+		infile, err := os.Open(file)
+		if err != nil {
+			return pack.Box{}, err
+		}
+
+		reader, err := pack.NewLASReader(infile)
+		infile.Close()
+		if err != nil {
+			return pack.Box{}, err
+		}
+
+		header := reader.Header()
+		bounds := header.Bounds()
+
+		for i := 0; i < 8; i++ {
+			v := vec3.T{bounds.Pos.X, bounds.Pos.Y, bounds.Pos.Z}
+			for axis := 0; axis < 3; axis++ {
+				if i&(1<<uint(axis)) != 0 {
+					v[axis] += bounds.Size
+				}
+			}
+
+			mat.TransformVec3(&v)
+			for axis := 0; axis < 3; axis++ {
+				min[axis] = math.Min(min[axis], v[axis])
+				max[axis] = math.Max(max[axis], v[axis])
+			}
+		}
+	}
+
+	size := math.Max(math.Max(max[0]-min[0], max[1]-min[1]), max[2]-min[2])
+	return pack.Box{pack.Point{min[0], min[1], min[2]}, size}, nil
+}
@@ -18,7 +18,7 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>.
 package main
 
 import (
-	"bufio"
+	"encoding/binary"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -26,6 +26,7 @@ import (
 	"os"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/andreas-jonsson/octatron/go3d/float64/mat4"
 	"github.com/andreas-jonsson/octatron/go3d/float64/vec3"
@@ -62,28 +63,87 @@ var formatLookup = map[string]pack.OctreeFormat{
 	"MipR4G4B4A4PackUI30": pack.MipR4G4B4A4PackUI30,
 	"MipR5G6B5PackUI30":   pack.MipR5G6B5PackUI30,
 	"MipR3G3B2PackUI31":   pack.MipR3G3B2PackUI31,
+
+	"MipR8G8B8MaskUI32": pack.MipR8G8B8MaskUI32,
+	"MipP8PackUI31":     pack.MipP8PackUI31,
+
+	"MipR8G8B8A8UnpackUI64": pack.MipR8G8B8A8UnpackUI64,
+
+	"MipR8G8B8A8PackRelUI28": pack.MipR8G8B8A8PackRelUI28,
+	"MipR4G4B4A4PackRelUI30": pack.MipR4G4B4A4PackRelUI30,
+	"MipR5G6B5PackRelUI30":   pack.MipR5G6B5PackRelUI30,
+	"MipR3G3B2PackRelUI31":   pack.MipR3G3B2PackRelUI31,
+}
+
+var codecLookup = map[string]func(level int) pack.Codec{
+	"zlib":  pack.NewZlibCodec,
+	"flate": pack.NewFlateCodec,
+	"gzip":  pack.NewGzipCodec,
+}
+
+var channelLookup = map[string]pack.Channels{
+	"intensity":      pack.ChannelIntensity,
+	"normal":         pack.ChannelNormal,
+	"classification": pack.ChannelClassification,
+	"count":          pack.ChannelPointCount,
+}
+
+func parseChannels(list string) (pack.Channels, error) {
+	var channels pack.Channels
+	for _, name := range strings.Split(list, ",") {
+		if name == "" {
+			continue
+		}
+
+		channel, ok := channelLookup[name]
+		if !ok {
+			return 0, fmt.Errorf("unknown channel: %v", name)
+		}
+		channels |= channel
+	}
+	return channels, nil
+}
+
+// parseMetadata parses a list of "key=value" pairs separated by commas.
+func parseMetadata(list string, meta pack.Metadata) error {
+	for _, pair := range strings.Split(list, ",") {
+		if pair == "" {
+			continue
+		}
+
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return fmt.Errorf("invalid metadata: %v", pair)
+		}
+		meta[kv[0]] = kv[1]
+	}
+	return nil
 }
 
 var arguments struct {
 	format, input, output     string
 	rotate, translate, bounds string
+	codec, channels, meta     string
 
-	vpa       int
-	threshold float64
+	vpa, level, blockSize int
+	threshold             float64
 
 	reflectComponent, compress bool
 	optimize, filter, dryRun   bool
+	bigEndian, dag             bool
 }
 
 func init() {
 	flag.Usage = func() {
-		fmt.Printf("Usage: packer [options]\n\n")
+		fmt.Printf("Usage: packer [options]\n")
+		fmt.Printf("       packer <command> [arguments]\n\n")
+		printCommands()
 		flag.PrintDefaults()
 	}
 
 	flag.StringVar(&arguments.format, "format", "MipR8G8B8A8PackUI28", "octree packing format")
-	flag.StringVar(&arguments.bounds, "bounds", "0,0,0,1", "octree bounding-box X,Y,Z,SIZE")
-	flag.StringVar(&arguments.input, "input", "cloud.xyz", "input files \"cloud0.xyz,cloud1.xyz\"")
+	flag.StringVar(&arguments.bounds, "bounds", "0,0,0,1", "octree bounding-box X,Y,Z,SIZE, or \"auto\" to use the bounds of las inputs")
+	flag.StringVar(&arguments.input, "input", "cloud.xyz", "input files \"cloud0.xyz,cloud1.ply\"")
 	flag.StringVar(&arguments.output, "output", "tree.oct", "")
 
 	flag.StringVar(&arguments.rotate, "rotate", "0,0,0", "YAW,PITCH,ROLL")
@@ -93,13 +153,24 @@ func init() {
 	flag.Float64Var(&arguments.threshold, "threshold", 0.25, "color-filter threshold")
 
 	flag.BoolVar(&arguments.compress, "compress", false, "use data compression")
+	flag.StringVar(&arguments.codec, "codec", "zlib", "compression codec, zlib, flate or gzip")
+	flag.IntVar(&arguments.level, "level", -1, "compression level 0-9, -1 for default")
+	flag.IntVar(&arguments.blockSize, "blocksize", 0, "nodes per compressed block, 0 compresses the tree as a single stream")
 	flag.BoolVar(&arguments.optimize, "optimize", true, "optimize tree")
 	flag.BoolVar(&arguments.filter, "filter", true, "apply color-filter")
-	flag.BoolVar(&arguments.reflectComponent, "reflect", true, "reflection component")
+	flag.BoolVar(&arguments.reflectComponent, "reflect", true, "reflection component, stored as intensity")
+	flag.StringVar(&arguments.channels, "channels", "", "attribute channels \"intensity,normal,classification,count\"")
 	flag.BoolVar(&arguments.dryRun, "dry", false, "dry-run, parses and transform cloud")
+	flag.BoolVar(&arguments.bigEndian, "bigendian", false, "write big-endian tree")
+	flag.BoolVar(&arguments.dag, "dag", false, "merge identical subtrees")
+	flag.StringVar(&arguments.meta, "meta", "", "metadata to store in the tree \"crs=EPSG:3006,units=m\"")
 }
 
 func main() {
+	if runCommand() {
+		return
+	}
+
 	flag.Parse()
 
 	outfile, err := os.Create(arguments.output)
@@ -126,35 +197,19 @@ func main() {
 			assert(err)
 			defer infile.Close()
 
-			var reads int64
 			size, _ := infile.Seek(0, 2)
 			infile.Seek(0, 0)
 
-			scanner := bufio.NewScanner(infile)
 			progress := -1
-
-			var (
-				s       pack.Sample
-				r, g, b byte
-			)
-
-			for scanner.Scan() {
-				text := scanner.Text()
-
-				var ref float64
-				if arguments.reflectComponent {
-					_, err := fmt.Sscan(text, &s.Pos.X, &s.Pos.Y, &s.Pos.Z, &ref, &r, &g, &b)
-					assert(err)
-				} else {
-					_, err := fmt.Sscan(text, &s.Pos.X, &s.Pos.Y, &s.Pos.Z, &r, &g, &b)
-					assert(err)
+			report := func(fraction float64) {
+				p := int(fraction * 100)
+				if p > progress {
+					progress = p
+					fmt.Printf("\rProgress: %v%% (%v/%v)", p, num+1, numFiles)
 				}
+			}
 
-				s.Col.R = float32(r) / 255
-				s.Col.G = float32(g) / 255
-				s.Col.B = float32(b) / 255
-				s.Col.A = 1
-
+			emit := func(s *pack.Sample) {
 				v := vec3.T{s.Pos.X, s.Pos.Y, s.Pos.Z}
 				mat.TransformVec3(&v)
 				s.Pos = pack.Point{v[0], v[1], v[2]}
@@ -164,19 +219,12 @@ func main() {
 				box.Pos.Z = math.Min(box.Pos.Z, s.Pos.Z)
 				box.Size = math.Max(math.Max(math.Max(s.Pos.X, s.Pos.Y), s.Pos.Z), box.Size) - math.Max(math.Max(box.Pos.X, box.Pos.Y), box.Pos.Z)
 
-				reads += int64(len(text) + 1)
-				p := int((float64(reads) / float64(size)) * 100)
-				if p > progress {
-					progress = p
-					fmt.Printf("\rProgress: %v%% (%v/%v)", p, num+1, numFiles)
-				}
-
 				if !arguments.dryRun {
-					samples <- s
+					samples <- *s
 				}
 			}
 
-			if err := scanner.Err(); err != nil {
+			if err := inputReader(file)(infile, size, emit, report); err != nil {
 				return err
 			}
 		}
@@ -192,7 +240,23 @@ func main() {
 	}
 
 	var bounds pack.Box
-	fmt.Sscanf(arguments.bounds, "%f,%f,%f,%f", &bounds.Pos.X, &bounds.Pos.Y, &bounds.Pos.Z, &bounds.Size)
+	if arguments.bounds == "auto" {
+		bounds, err = lasBounds(inputFiles, &mat)
+		assert(err)
+		fmt.Println("Bounds:", bounds)
+	} else {
+		fmt.Sscanf(arguments.bounds, "%f,%f,%f,%f", &bounds.Pos.X, &bounds.Pos.Y, &bounds.Pos.Z, &bounds.Size)
+	}
+
+	channels, err := parseChannels(arguments.channels)
+	assert(err)
+
+	meta := pack.Metadata{
+		"source":  arguments.input,
+		"created": time.Now().UTC().Format(time.RFC3339),
+		"packer":  strings.Join(os.Args[1:], " "),
+	}
+	assert(parseMetadata(arguments.meta, meta))
 
 	cfg := pack.BuildConfig{
 		Worker:         parser,
@@ -203,6 +267,14 @@ func main() {
 		Optimize:       arguments.optimize,
 		ColorFilter:    arguments.filter,
 		ColorThreshold: float32(arguments.threshold),
+		Transform:      *mat.Array(),
+		Channels:       channels,
+		Deduplicate:    arguments.dag,
+		Metadata:       meta,
+	}
+
+	if arguments.bigEndian {
+		cfg.ByteOrder = binary.BigEndian
 	}
 
 	status, err := pack.BuildTree(&cfg)
@@ -217,7 +289,15 @@ func main() {
 
 		_, err = outfile.Seek(0, 0)
 		assert(err)
-		assert(pack.CompressTree(outfile, zipfile))
+		newCodec, ok := codecLookup[arguments.codec]
+		if !ok {
+			assert(fmt.Errorf("unknown codec: %v", arguments.codec))
+		}
+		if arguments.blockSize > 0 {
+			assert(pack.CompressTreeBlocks(outfile, zipfile, newCodec(arguments.level), arguments.blockSize))
+		} else {
+			assert(pack.CompressTree(outfile, zipfile, newCodec(arguments.level)))
+		}
 
 		outfile.Close()
 		zipfilePath := zipfile.Name()
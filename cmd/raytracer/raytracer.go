@@ -150,10 +150,11 @@ func main() {
 	}
 	defer fp.Close()
 
-	tree, vpa, err := trace.LoadOctree(fp)
+	tree, vpa, bounds, err := trace.MapOctree(fp)
 	if err != nil {
 		panic(err)
 	}
+	defer trace.UnmapOctree(tree)
 	maxDepth := trace.TreeWidthToDepth(vpa)
 
 	sdl.Init(sdl.INIT_EVERYTHING)
@@ -194,6 +195,19 @@ func main() {
 	var pos [3]float32
 	fmt.Sscanf(arguments.treePosition, "%f,%f,%f", &pos[0], &pos[1], &pos[2])
 
+	// Place the tree from its recorded bounds unless told otherwise.
+	if bounds.Size > 0 {
+		explicit := make(map[string]bool)
+		flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+		if !explicit["pos"] {
+			pos = [3]float32{float32(bounds.Pos.X), float32(bounds.Pos.Y), float32(bounds.Pos.Z)}
+		}
+		if !explicit["scale"] {
+			arguments.treeScale = bounds.Size
+		}
+	}
+
 	cfg := trace.Config{
 		FieldOfView:   float32(arguments.fieldOfView),
 		TreeScale:     float32(arguments.treeScale),
@@ -35,6 +35,7 @@ import (
 
 	"golang.org/x/net/websocket"
 
+	"github.com/andreas-jonsson/octatron/pack"
 	"github.com/andreas-jonsson/octatron/trace"
 )
 
@@ -48,6 +49,8 @@ var (
 var loadedTree struct {
 	maxDepth int
 	tree     trace.Octree
+	position trace.Vec3
+	scale    float32
 	pal      color.Palette
 	rawPal   []byte
 }
@@ -98,14 +101,42 @@ func loadTree(file string) error {
 	}
 	defer treeFp.Close()
 
+	var header pack.OctreeHeader
+	if err := pack.DecodeHeader(treeFp, &header); err != nil {
+		return err
+	}
+
+	// Paletted trees carry their own palette.
+	if header.Format.Paletted() {
+		pal = make([]color.Color, len(header.Palette))
+		for i, c := range header.Palette {
+			pal[i] = color.RGBA{c[0], c[1], c[2], 0xFF}
+		}
+	}
+
+	if _, err := treeFp.Seek(0, 0); err != nil {
+		return err
+	}
+
 	log.Println("loading octree:", file)
-	tree, vpa, err := trace.LoadOctree(treeFp)
+	tree, vpa, bounds, err := trace.MapOctree(treeFp)
 	if err != nil {
 		return err
 	}
 
+	// Release the mapping of a replaced tree, it must no longer be rendered.
+	if loadedTree.tree != nil {
+		trace.UnmapOctree(loadedTree.tree)
+	}
+
 	loadedTree.maxDepth = trace.TreeWidthToDepth(vpa)
 	loadedTree.tree = tree
+	loadedTree.scale = 1
+
+	if bounds.Size > 0 {
+		loadedTree.position = trace.Vec3{float32(bounds.Pos.X), float32(bounds.Pos.Y), float32(bounds.Pos.Z)}
+		loadedTree.scale = float32(bounds.Size)
+	}
 
 	paletteFile := file + ".png"
 	paletteFp, err := os.Open(paletteFile)
@@ -143,6 +174,13 @@ func loadTree(file string) error {
 	return nil
 }
 
+// treePosition maps a camera position given in tree units, where the tree
+// spans 0 to 1 on each axis, to the placement from the bounds of the tree.
+func treePosition(pos [3]float32) trace.Vec3 {
+	p, s := loadedTree.position, loadedTree.scale
+	return trace.Vec3{p[0] + pos[0]*s, p[1] + pos[1]*s, p[2] + pos[2]*s}
+}
+
 func renderServer(ws *websocket.Conn) {
 	addr := ws.RemoteAddr()
 	log.Println("new connection:", addr)
@@ -182,7 +220,8 @@ func renderServer(ws *websocket.Conn) {
 
 	cfg := trace.Config{
 		FieldOfView:   setup.FieldOfView,
-		TreeScale:     1,
+		TreeScale:     loadedTree.scale,
+		TreePosition:  loadedTree.position,
 		ViewDist:      float32(arguments.viewDistance),
 		Images:        surfaces,
 		Jitter:        true,
@@ -220,7 +259,7 @@ func renderServer(ws *websocket.Conn) {
 	for {
 		update := <-updateChan
 		camera := trace.FreeFlightCamera{
-			Pos:  update.Camera.Position,
+			Pos:  treePosition(update.Camera.Position),
 			XRot: update.Camera.XRot,
 			YRot: update.Camera.YRot,
 		}
@@ -0,0 +1,208 @@
+/*
+Copyright (C) 2015-2016 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package pack
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// Channels is a set of optional per-voxel attribute channels. The channels
+// of a tree are declared in its header and stored after every node, in the
+// order they are declared below.
+type Channels uint32
+
+const (
+	ChannelIntensity Channels = 1 << iota
+	ChannelNormal
+	ChannelClassification
+	ChannelPointCount
+
+	allChannels = ChannelIntensity | ChannelNormal | ChannelClassification | ChannelPointCount
+)
+
+type Attributes struct {
+	Intensity      float32
+	Normal         [3]float32
+	Classification uint8
+	PointCount     uint32
+}
+
+func (c Channels) Has(channel Channels) bool {
+	return c&channel == channel
+}
+
+func (c Channels) Size() int {
+	size := 0
+	if c.Has(ChannelIntensity) {
+		size += 4
+	}
+	if c.Has(ChannelNormal) {
+		size += 12
+	}
+	if c.Has(ChannelClassification) {
+		size += 1
+	}
+	if c.Has(ChannelPointCount) {
+		size += 4
+	}
+	return size
+}
+
+// accAttributes accumulates the attributes of every sample inserted in a
+// node of the builder. The classification is a majority vote.
+type accAttributes struct {
+	Intensity float64
+	Normal    [3]float64
+	Class     uint32
+	Votes     uint32
+}
+
+func (acc *accAttributes) add(attr *Attributes, weight uint64) {
+	acc.Intensity += float64(attr.Intensity) * float64(weight)
+	for i, n := range attr.Normal {
+		acc.Normal[i] += float64(n) * float64(weight)
+	}
+
+	class := uint32(attr.Classification)
+	votes := uint32(weight)
+	if acc.Votes == 0 {
+		acc.Class = class
+		acc.Votes = votes
+	} else if acc.Class == class {
+		acc.Votes += votes
+	} else if acc.Votes >= votes {
+		acc.Votes -= votes
+	} else {
+		acc.Class = class
+		acc.Votes = votes - acc.Votes
+	}
+}
+
+func (acc *accAttributes) attributes(attr *Attributes, count uint64) {
+	*attr = Attributes{Classification: uint8(acc.Class), PointCount: uint32(count)}
+	if count > math.MaxUint32 {
+		attr.PointCount = math.MaxUint32
+	}
+
+	if count > 0 {
+		attr.Intensity = float32(acc.Intensity / float64(count))
+	}
+
+	length := math.Sqrt(acc.Normal[0]*acc.Normal[0] + acc.Normal[1]*acc.Normal[1] + acc.Normal[2]*acc.Normal[2])
+	if length > 0 {
+		for i, n := range acc.Normal {
+			attr.Normal[i] = float32(n / length)
+		}
+	}
+}
+
+func (h *OctreeHeader) attributeSize() int {
+	if h.Format == mipR64G64B64A64S64UnpackUI64 {
+		if h.Channels == 0 {
+			return 0
+		}
+		return binary.Size(accAttributes{})
+	}
+	return h.Channels.Size()
+}
+
+// NodeSize returns the size of a node, including its attributes.
+func (h *OctreeHeader) NodeSize() int {
+	return h.Format.NodeSize() + h.attributeSize()
+}
+
+func decodeAttributes(reader io.Reader, header *OctreeHeader, attr *Attributes, count uint64) error {
+	var discard Attributes
+	if attr == nil {
+		attr = &discard
+	}
+
+	order := header.ByteOrder()
+	if header.Format == mipR64G64B64A64S64UnpackUI64 {
+		if header.Channels == 0 {
+			*attr = Attributes{PointCount: uint32(count)}
+			return nil
+		}
+
+		var acc accAttributes
+		if err := binary.Read(reader, order, &acc); err != nil {
+			return err
+		}
+		acc.attributes(attr, count)
+		return nil
+	}
+
+	*attr = Attributes{}
+	channels := header.Channels
+
+	if channels.Has(ChannelIntensity) {
+		if err := binary.Read(reader, order, &attr.Intensity); err != nil {
+			return err
+		}
+	}
+	if channels.Has(ChannelNormal) {
+		if err := binary.Read(reader, order, &attr.Normal); err != nil {
+			return err
+		}
+	}
+	if channels.Has(ChannelClassification) {
+		if err := binary.Read(reader, order, &attr.Classification); err != nil {
+			return err
+		}
+	}
+	if channels.Has(ChannelPointCount) {
+		if err := binary.Read(reader, order, &attr.PointCount); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func encodeAttributes(writer io.Writer, header *OctreeHeader, attr *Attributes) error {
+	var zero Attributes
+	if attr == nil {
+		attr = &zero
+	}
+
+	order := header.ByteOrder()
+	channels := header.Channels
+
+	if channels.Has(ChannelIntensity) {
+		if err := binary.Write(writer, order, attr.Intensity); err != nil {
+			return err
+		}
+	}
+	if channels.Has(ChannelNormal) {
+		if err := binary.Write(writer, order, attr.Normal); err != nil {
+			return err
+		}
+	}
+	if channels.Has(ChannelClassification) {
+		if err := binary.Write(writer, order, attr.Classification); err != nil {
+			return err
+		}
+	}
+	if channels.Has(ChannelPointCount) {
+		if err := binary.Write(writer, order, attr.PointCount); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+/*
+Copyright (C) 2015-2016 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package pack
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"testing"
+)
+
+func testAttributeWorker(samples chan<- Sample) error {
+	input := make(chan Sample)
+	result := make(chan error, 1)
+
+	go func() {
+		result <- testSampleWorker(input)
+		close(input)
+	}()
+
+	i := 0
+	for s := range input {
+		s.Attributes = Attributes{float32(i), [3]float32{0, 0, 2}, 2, 0}
+		samples <- s
+		i++
+	}
+	return <-result
+}
+
+func testRootAttributes(data []byte) (OctreeHeader, Attributes) {
+	var (
+		color Color
+		attr  Attributes
+		ch    [8]uint64
+	)
+
+	reader, err := NewOctreeReader(bytes.NewReader(data))
+	if err != nil {
+		panic(err)
+	}
+	defer reader.Close()
+
+	if err := reader.ReadNode(0, &color, &attr, ch[:]); err != nil {
+		panic(err)
+	}
+	return reader.Header(), attr
+}
+
+func TestAttributeChannels(t *testing.T) {
+	expected := Attributes{3, [3]float32{0, 0, 1}, 2, 7}
+
+	fp, err := ioutil.TempFile("", "")
+	if err != nil {
+		panic(err)
+	}
+	defer removeTempFile(fp)
+
+	for _, optimize := range []bool{false, true} {
+		var built, transcoded bytes.Buffer
+
+		cfg := testBuildConfig(MipR8G8B8A8UnpackUI32, &built)
+		cfg.Worker = testAttributeWorker
+		cfg.Optimize = optimize
+		cfg.Channels = allChannels
+
+		if _, err := BuildTree(&cfg); err != nil {
+			panic(err)
+		}
+
+		if err := TranscodeTree(bytes.NewReader(built.Bytes()), &transcoded, MipR5G6B5PackUI30, binary.BigEndian); err != nil {
+			panic(err)
+		}
+
+		if err := fp.Truncate(0); err != nil {
+			panic(err)
+		}
+
+		if _, err := fp.Seek(0, 0); err != nil {
+			panic(err)
+		}
+
+		if err := CompressTreeBlocks(bytes.NewReader(transcoded.Bytes()), fp, NewZlibCodec(zlib.DefaultCompression), 4); err != nil {
+			panic(err)
+		}
+
+		compressed, err := ioutil.ReadFile(fp.Name())
+		if err != nil {
+			panic(err)
+		}
+
+		for _, data := range [][]byte{built.Bytes(), transcoded.Bytes(), compressed} {
+			header, attr := testRootAttributes(data)
+			if header.Channels != allChannels {
+				panic("attribute channels were not preserved")
+			}
+
+			if attr != expected {
+				panic(fmt.Errorf("root attributes %+v, expected %+v", attr, expected))
+			}
+
+			report, err := Validate(bytes.NewReader(data))
+			if err != nil {
+				panic(err)
+			}
+
+			if report.Valid() == false {
+				panic(fmt.Errorf("tree with attributes did not validate: %+v", report))
+			}
+		}
+	}
+}
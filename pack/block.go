@@ -0,0 +1,270 @@
+/*
+Copyright (C) 2015-2016 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package pack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// Number of decompressed blocks kept by an OctreeReader.
+const blockCacheSize = 16
+
+// A blocked tree stores its nodes in groups of BlockSize nodes, each group
+// compressed on its own. The header is followed by a table of NumBlocks+1
+// offsets, relative to the start of the header, where block i occupies the
+// bytes between offset i and i+1.
+
+func (h *OctreeHeader) NumBlocks() uint64 {
+	if h.BlockSize == 0 {
+		return 0
+	}
+	return (h.NumNodes + uint64(h.BlockSize) - 1) / uint64(h.BlockSize)
+}
+
+func readBlockTable(reader io.Reader, header *OctreeHeader) ([]uint64, error) {
+	if header.BlockSize == 0 {
+		return nil, errBlockSize
+	}
+
+	offsets := make([]uint64, header.NumBlocks()+1)
+	if err := binary.Read(reader, header.ByteOrder(), offsets); err != nil {
+		return nil, err
+	}
+
+	for i := 1; i < len(offsets); i++ {
+		if offsets[i] < offsets[i-1] {
+			return nil, errBlockTable
+		}
+	}
+	return offsets, nil
+}
+
+// CompressTreeBlocks compresses an uncompressed tree with codec, in blocks
+// of blockSize nodes, so single nodes can be read without decompressing
+// the whole tree.
+func CompressTreeBlocks(reader io.Reader, writer io.WriteSeeker, codec Codec, blockSize int) error {
+	var (
+		header   OctreeHeader
+		color    Color
+		attr     Attributes
+		children [8]uint64
+	)
+
+	if blockSize <= 0 {
+		return errBlockSize
+	}
+
+	if err := DecodeHeader(reader, &header); err != nil {
+		return err
+	}
+
+	if header.Compressed() == true {
+		return errInputIsCompressed
+	}
+
+	base, err := writer.Seek(0, 1)
+	if err != nil {
+		return err
+	}
+
+	outputHeader := header
+	outputHeader.Version = binaryVersion
+	outputHeader.Codec = codec.ID()
+	outputHeader.Flags |= compressedMask | blockedMask
+	outputHeader.BlockSize = uint32(blockSize)
+
+	if err := EncodeHeader(writer, outputHeader); err != nil {
+		return err
+	}
+
+	offsets := make([]uint64, outputHeader.NumBlocks()+1)
+	if err := binary.Write(writer, outputHeader.ByteOrder(), offsets); err != nil {
+		return err
+	}
+
+	for block := range offsets[:len(offsets)-1] {
+		pos, err := writer.Seek(0, 1)
+		if err != nil {
+			return err
+		}
+		offsets[block] = uint64(pos - base)
+
+		blockWriter, err := codec.NewWriter(writer)
+		if err != nil {
+			return err
+		}
+
+		first := uint64(block) * uint64(blockSize)
+		for i := first; i < header.NumNodes && i < first+uint64(blockSize); i++ {
+			if err := DecodeNode(reader, &header, i, &color, &attr, children[:]); err != nil {
+				blockWriter.Close()
+				return err
+			}
+
+			if err := EncodeNode(blockWriter, &outputHeader, i, color, &attr, children[:]); err != nil {
+				blockWriter.Close()
+				return err
+			}
+		}
+
+		if err := blockWriter.Close(); err != nil {
+			return err
+		}
+	}
+
+	end, err := writer.Seek(0, 1)
+	if err != nil {
+		return err
+	}
+	offsets[len(offsets)-1] = uint64(end - base)
+
+	if _, err := writer.Seek(base+int64(outputHeader.Size()), 0); err != nil {
+		return err
+	}
+
+	if err := binary.Write(writer, outputHeader.ByteOrder(), offsets); err != nil {
+		return err
+	}
+
+	_, err = writer.Seek(end, 0)
+	return err
+}
+
+// blockReader streams the nodes of a blocked tree, one block after the other.
+type blockReader struct {
+	reader  io.Reader
+	codec   Codec
+	offsets []uint64
+	next    int
+
+	block   io.Reader
+	current io.ReadCloser
+}
+
+func (r *blockReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if r.next+1 >= len(r.offsets) {
+				return 0, io.EOF
+			}
+
+			r.block = io.LimitReader(r.reader, int64(r.offsets[r.next+1]-r.offsets[r.next]))
+			r.next++
+
+			current, err := r.codec.NewReader(r.block)
+			if err != nil {
+				return 0, err
+			}
+			r.current = current
+		}
+
+		n, err := r.current.Read(p)
+		if err == io.EOF {
+			if err := r.Close(); err != nil {
+				return n, err
+			}
+
+			// Skip anything the codec left behind in the block.
+			if _, err := io.Copy(ioutil.Discard, r.block); err != nil {
+				return n, err
+			}
+
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (r *blockReader) Close() error {
+	if r.current == nil {
+		return nil
+	}
+
+	err := r.current.Close()
+	r.current = nil
+	return err
+}
+
+// blockCache holds recently decompressed blocks for an OctreeReader.
+type blockCache struct {
+	lock   sync.Mutex
+	blocks map[uint64][]byte
+	order  []uint64
+}
+
+func newBlockCache() *blockCache {
+	return &blockCache{blocks: make(map[uint64][]byte)}
+}
+
+func (c *blockCache) get(block uint64) ([]byte, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	data, ok := c.blocks[block]
+	return data, ok
+}
+
+func (c *blockCache) put(block uint64, data []byte) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if _, ok := c.blocks[block]; ok {
+		return
+	}
+
+	if len(c.order) == blockCacheSize {
+		delete(c.blocks, c.order[0])
+		c.order = c.order[1:]
+	}
+
+	c.blocks[block] = data
+	c.order = append(c.order, block)
+}
+
+func (r *OctreeReader) readBlock(block uint64) ([]byte, error) {
+	if data, ok := r.cache.get(block); ok == true {
+		return data, nil
+	}
+
+	start, end := r.offsets[block], r.offsets[block+1]
+	compressed := make([]byte, end-start)
+	if n, err := r.reader.ReadAt(compressed, int64(start)); n < len(compressed) {
+		return nil, err
+	}
+
+	blockReader, err := r.codec.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	defer blockReader.Close()
+
+	data, err := ioutil.ReadAll(blockReader)
+	if err != nil {
+		return nil, err
+	}
+
+	r.cache.put(block, data)
+	return data, nil
+}
@@ -0,0 +1,108 @@
+/*
+Copyright (C) 2015-2016 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package pack
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestBlockCompression(t *testing.T) {
+	fp, err := ioutil.TempFile("", "")
+	if err != nil {
+		panic(err)
+	}
+	defer removeTempFile(fp)
+
+	for _, format := range testFormats {
+		for _, blockSize := range []int{1, 4, 1000} {
+			data := buildTestTree(format)
+			header, colors, children := decodeTestTree(data)
+
+			if err := fp.Truncate(0); err != nil {
+				panic(err)
+			}
+
+			if _, err := fp.Seek(0, 0); err != nil {
+				panic(err)
+			}
+
+			if err := CompressTreeBlocks(bytes.NewReader(data), fp, NewFlateCodec(flate.BestSpeed), blockSize); err != nil {
+				panic(err)
+			}
+
+			testBlockedTree(fp, format, header, colors, children)
+		}
+	}
+}
+
+func testBlockedTree(fp *os.File, format OctreeFormat, header OctreeHeader, colors []Color, children [][8]uint64) {
+	reader, err := NewOctreeReader(fp)
+	if err != nil {
+		panic(err)
+	}
+	defer reader.Close()
+
+	if reader.header.Blocked() == false || reader.temp != nil {
+		panic("expected a blocked reader")
+	}
+
+	var (
+		color Color
+		ch    [8]uint64
+	)
+
+	for i := len(colors) - 1; i >= 0; i-- {
+		if err := reader.ReadNode(uint64(i), &color, nil, ch[:]); err != nil {
+			panic(err)
+		}
+
+		if color != colors[i] || ch != children[i] {
+			panic(fmt.Errorf("node %v mismatch in format %v", i, format))
+		}
+	}
+
+	if _, err := fp.Seek(0, 0); err != nil {
+		panic(err)
+	}
+
+	var decompressed bytes.Buffer
+	if err := DecompressTree(fp, &decompressed); err != nil {
+		panic(err)
+	}
+
+	_, streamColors, streamChildren := decodeTestTree(decompressed.Bytes())
+	for i := range colors {
+		if streamColors[i] != colors[i] || streamChildren[i] != children[i] {
+			panic(fmt.Errorf("streamed node %v mismatch in format %v", i, format))
+		}
+	}
+
+	report, err := Validate(fp)
+	if err != nil {
+		panic(err)
+	}
+
+	if report.Valid() == false || report.NumLeafs != header.NumLeafs {
+		panic(fmt.Errorf("blocked tree did not validate: %+v", report))
+	}
+}
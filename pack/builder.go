@@ -37,6 +37,11 @@ type BuildConfig struct {
 	Optimize       bool
 	ColorFilter    bool
 	ColorThreshold float32
+	Transform      [16]float64
+	ByteOrder      binary.ByteOrder
+	Channels       Channels
+	Deduplicate    bool
+	Metadata       Metadata
 }
 
 type BuildStatus struct {
@@ -46,11 +51,15 @@ type BuildStatus struct {
 type Sample struct {
 	Pos Point
 	Col Color
+
+	// Attributes are only stored for the channels set in BuildConfig.
+	// A PointCount above one makes the sample count as that many points.
+	Attributes Attributes
 }
 
 type accNode struct {
 	Color    [5]uint64
-	Children [8]uint32
+	Children [8]uint64
 }
 
 var childPositions = [...]Point{
@@ -92,8 +101,7 @@ func BuildTree(cfg *BuildConfig) (BuildStatus, error) {
 	}
 
 	header.NumNodes++
-	var rootNode accNode
-	if err := binary.Write(fp, binary.LittleEndian, rootNode); err != nil {
+	if err := writeAccNode(fp, header); err != nil {
 		return status, err
 	}
 
@@ -120,7 +128,7 @@ func BuildTree(cfg *BuildConfig) (BuildStatus, error) {
 		return status, err
 	}
 
-	if err := binary.Write(fp, binary.LittleEndian, header); err != nil {
+	if err := EncodeHeader(fp, *header); err != nil {
 		return status, err
 	}
 
@@ -128,59 +136,130 @@ func BuildTree(cfg *BuildConfig) (BuildStatus, error) {
 		return status, err
 	}
 
+	var dagFile *os.File
+	writer := cfg.Writer
+
+	if cfg.Deduplicate == true {
+		if dagFile, err = ioutil.TempFile("", ""); err != nil {
+			return status, err
+		}
+		defer removeTempFile(dagFile)
+		writer = dagFile
+	}
+
 	if cfg.Optimize == true {
-		status.Status, err = OptimizeTree(fp, cfg.Writer, cfg.Format, cfg.ColorThreshold, cfg.ColorFilter)
+		status.Status, err = OptimizeTree(fp, writer, cfg.Format, cfg.ColorThreshold, cfg.ColorFilter)
 		if err != nil {
 			return status, err
 		}
 	} else {
-		if err := TranscodeTree(fp, cfg.Writer, cfg.Format); err != nil {
+		if err := TranscodeTree(fp, writer, cfg.Format, header.ByteOrder()); err != nil {
 			return status, err
 		}
 	}
 
+	if cfg.Deduplicate == true {
+		dagStatus, err := DeduplicateTree(dagFile, cfg.Writer, cfg.Format)
+		if err != nil {
+			return status, err
+		}
+		status.Status.DedupRatio = dagStatus.DedupRatio
+	}
+
 	return status, nil
 }
 
 func writeOctreeHeader(cfg *BuildConfig, writer io.Writer) (*OctreeHeader, error) {
 	var header OctreeHeader
-	header.Sign[0] = 0x1b
-	header.Sign[1] = 0x6f
-	header.Sign[2] = 0x63
-	header.Sign[3] = 0x74
+	header.Sign = octreeSignature
 	header.Version = binaryVersion
-	header.Format = mipR64G64B64A64S64UnpackUI32
-	header.Unused = 0x0
+	header.Format = mipR64G64B64A64S64UnpackUI64
+	header.Codec = CodecZlib
 	header.NumNodes = 0
 	header.NumLeafs = 0
 	header.VoxelsPerAxis = uint32(cfg.VoxelsPerAxis)
-	return &header, binary.Write(writer, binary.LittleEndian, header)
+	header.Bounds = cfg.Bounds
+	header.Transform = cfg.Transform
+	header.SetByteOrder(cfg.ByteOrder)
+	header.Channels = cfg.Channels
+	if err := header.EncodeMetadata(cfg.Metadata); err != nil {
+		return nil, err
+	}
+	return &header, EncodeHeader(writer, header)
 }
 
-func insertSample(cfg *BuildConfig, header *OctreeHeader, readWriter io.ReadWriteSeeker, sample Sample, bounds Box, voxelRes int) error {
+func writeAccNode(writer io.Writer, header *OctreeHeader) error {
 	var node accNode
+	if err := binary.Write(writer, header.ByteOrder(), node); err != nil {
+		return err
+	}
+
+	if header.Channels != 0 {
+		var attr accAttributes
+		return binary.Write(writer, header.ByteOrder(), attr)
+	}
+	return nil
+}
+
+func insertSample(cfg *BuildConfig, header *OctreeHeader, readWriter io.ReadWriteSeeker, sample Sample, bounds Box, voxelRes int) error {
+	var (
+		node accNode
+		attr accAttributes
+	)
+
+	nodeSize := header.NodeSize()
+	attrSize := nodeSize - mipR64G64B64A64S64UnpackUI64.NodeSize()
+
+	weight := uint64(1)
+	if sample.Attributes.PointCount > 1 {
+		weight = uint64(sample.Attributes.PointCount)
+	}
+
 	for {
-		if err := binary.Read(readWriter, binary.LittleEndian, &node); err != nil {
+		if err := binary.Read(readWriter, header.ByteOrder(), &node); err != nil {
 			return err
 		}
 
-		if _, err := readWriter.Seek(int64(-mipR64G64B64A64S64UnpackUI32.NodeSize()), 1); err != nil {
+		if header.Channels != 0 {
+			if err := binary.Read(readWriter, header.ByteOrder(), &attr); err != nil {
+				return err
+			}
+		}
+
+		if _, err := readWriter.Seek(int64(-nodeSize), 1); err != nil {
 			return err
 		}
 
 		color := sample.Col
-		node.Color[0] += uint64(color.R * 255)
-		node.Color[1] += uint64(color.G * 255)
-		node.Color[2] += uint64(color.B * 255)
-		node.Color[3] += uint64(color.A * 255)
-		node.Color[4]++
+		node.Color[0] += uint64(color.R*255) * weight
+		node.Color[1] += uint64(color.G*255) * weight
+		node.Color[2] += uint64(color.B*255) * weight
+		node.Color[3] += uint64(color.A*255) * weight
+		node.Color[4] += weight
 
-		if err := binary.Write(readWriter, binary.LittleEndian, node.Color); err != nil {
+		if err := binary.Write(readWriter, header.ByteOrder(), node.Color); err != nil {
 			return err
 		}
 
+		if header.Channels != 0 {
+			attr.add(&sample.Attributes, weight)
+			if err := binary.Write(readWriter, header.ByteOrder(), node.Children); err != nil {
+				return err
+			}
+
+			if err := binary.Write(readWriter, header.ByteOrder(), attr); err != nil {
+				return err
+			}
+
+			if _, err := readWriter.Seek(int64(-attrSize-binary.Size(node.Children)), 1); err != nil {
+				return err
+			}
+		}
+
 		if voxelRes == 1 {
-			header.NumLeafs++
+			if node.Color[4] == weight {
+				header.NumLeafs++
+			}
 			return nil
 		}
 
@@ -210,8 +289,8 @@ func insertSample(cfg *BuildConfig, header *OctreeHeader, readWriter io.ReadWrit
 						return err
 					}
 
-					node.Children[i] = uint32((newPos - int64(header.Size())) / int64(mipR64G64B64A64S64UnpackUI32.NodeSize()))
-					if err := binary.Write(readWriter, binary.LittleEndian, node.Children); err != nil {
+					node.Children[i] = uint64((newPos - int64(header.Size())) / int64(nodeSize))
+					if err := binary.Write(readWriter, header.ByteOrder(), node.Children); err != nil {
 						return err
 					}
 
@@ -220,16 +299,15 @@ func insertSample(cfg *BuildConfig, header *OctreeHeader, readWriter io.ReadWrit
 					}
 
 					header.NumNodes++
-					var newNode accNode
-					if err := binary.Write(readWriter, binary.LittleEndian, newNode); err != nil {
+					if err := writeAccNode(readWriter, header); err != nil {
 						return err
 					}
 
-					if _, err := readWriter.Seek(int64(-mipR64G64B64A64S64UnpackUI32.NodeSize()), 1); err != nil {
+					if _, err := readWriter.Seek(int64(-nodeSize), 1); err != nil {
 						return err
 					}
 				} else {
-					if _, err := readWriter.Seek(int64(int(child)*mipR64G64B64A64S64UnpackUI32.NodeSize()+header.Size()), 0); err != nil {
+					if _, err := readWriter.Seek(int64(int(child)*nodeSize+header.Size()), 0); err != nil {
 						return err
 					}
 				}
@@ -19,6 +19,8 @@ package pack
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/binary"
 	"fmt"
 	"os"
 	"testing"
@@ -64,7 +66,7 @@ func TestBuildTree(t *testing.T) {
 	}
 
 	bounds := Box{Point{0, 0, 0}, 80}
-	cfg := BuildConfig{parser, outfile, bounds, 8, MipR8G8B8A8UnpackUI32, true, true, 0.25}
+	cfg := BuildConfig{parser, outfile, bounds, 8, MipR8G8B8A8UnpackUI32, true, true, 0.25, [16]float64{}, nil, 0, false, nil}
 
 	status, err := BuildTree(&cfg)
 	if err != nil {
@@ -72,3 +74,34 @@ func TestBuildTree(t *testing.T) {
 	}
 	fmt.Println(status)
 }
+
+func TestBuildByteOrder(t *testing.T) {
+	for _, optimize := range []bool{false, true} {
+		var littleEndian, bigEndian bytes.Buffer
+
+		cfg := testBuildConfig(MipR8G8B8A8UnpackUI32, &littleEndian)
+		cfg.Optimize = optimize
+		if _, err := BuildTree(&cfg); err != nil {
+			panic(err)
+		}
+
+		cfg.Writer = &bigEndian
+		cfg.ByteOrder = binary.BigEndian
+		if _, err := BuildTree(&cfg); err != nil {
+			panic(err)
+		}
+
+		_, colors, children := decodeTestTree(littleEndian.Bytes())
+		header, beColors, beChildren := decodeTestTree(bigEndian.Bytes())
+
+		if header.BigEndian() == false || len(colors) != len(beColors) {
+			panic("big-endian build mismatch")
+		}
+
+		for i := range colors {
+			if colors[i] != beColors[i] || children[i] != beChildren[i] {
+				panic(fmt.Errorf("node %v mismatch", i))
+			}
+		}
+	}
+}
@@ -0,0 +1,167 @@
+/*
+Copyright (C) 2015-2016 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package pack
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// CodecID identifies the compression codec of a tree and is stored in the header.
+type CodecID byte
+
+const (
+	CodecZlib CodecID = iota
+	CodecNone
+	CodecFlate
+	CodecGzip
+)
+
+// Codec compresses the node data of a tree. Codecs are registered with
+// RegisterCodec and looked up by the ID stored in the header when a tree
+// is read, so IDs must be unique. IDs below 128 are reserved for this package.
+type Codec interface {
+	ID() CodecID
+	NewReader(reader io.Reader) (io.ReadCloser, error)
+	NewWriter(writer io.Writer) (io.WriteCloser, error)
+}
+
+var (
+	codecLock sync.RWMutex
+	codecs    = make(map[CodecID]Codec)
+)
+
+func init() {
+	RegisterCodec(NewZlibCodec(zlib.DefaultCompression))
+	RegisterCodec(noneCodec{})
+	RegisterCodec(NewFlateCodec(flate.DefaultCompression))
+	RegisterCodec(NewGzipCodec(gzip.DefaultCompression))
+}
+
+// RegisterCodec makes a codec available for reading trees. It panics if
+// codec is nil or if a codec with the same ID is already registered.
+func RegisterCodec(codec Codec) {
+	codecLock.Lock()
+	defer codecLock.Unlock()
+
+	if codec == nil {
+		panic("pack: register nil codec")
+	}
+
+	if _, dup := codecs[codec.ID()]; dup {
+		panic("pack: codec registered twice")
+	}
+	codecs[codec.ID()] = codec
+}
+
+func LookupCodec(id CodecID) (Codec, error) {
+	codecLock.RLock()
+	defer codecLock.RUnlock()
+
+	codec, ok := codecs[id]
+	if !ok {
+		return nil, errUnknownCodec
+	}
+	return codec, nil
+}
+
+type noneCodec struct{}
+
+func (noneCodec) ID() CodecID {
+	return CodecNone
+}
+
+func (noneCodec) NewReader(reader io.Reader) (io.ReadCloser, error) {
+	return ioutil.NopCloser(reader), nil
+}
+
+func (noneCodec) NewWriter(writer io.Writer) (io.WriteCloser, error) {
+	return nopWriteCloser{writer}, nil
+}
+
+type zlibCodec struct {
+	level int
+}
+
+func NewZlibCodec(level int) Codec {
+	return zlibCodec{level}
+}
+
+func (zlibCodec) ID() CodecID {
+	return CodecZlib
+}
+
+func (zlibCodec) NewReader(reader io.Reader) (io.ReadCloser, error) {
+	return zlib.NewReader(reader)
+}
+
+func (c zlibCodec) NewWriter(writer io.Writer) (io.WriteCloser, error) {
+	return zlib.NewWriterLevel(writer, c.level)
+}
+
+type flateCodec struct {
+	level int
+}
+
+func NewFlateCodec(level int) Codec {
+	return flateCodec{level}
+}
+
+func (flateCodec) ID() CodecID {
+	return CodecFlate
+}
+
+func (flateCodec) NewReader(reader io.Reader) (io.ReadCloser, error) {
+	return flate.NewReader(reader), nil
+}
+
+func (c flateCodec) NewWriter(writer io.Writer) (io.WriteCloser, error) {
+	return flate.NewWriter(writer, c.level)
+}
+
+type gzipCodec struct {
+	level int
+}
+
+func NewGzipCodec(level int) Codec {
+	return gzipCodec{level}
+}
+
+func (gzipCodec) ID() CodecID {
+	return CodecGzip
+}
+
+func (gzipCodec) NewReader(reader io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(reader)
+}
+
+func (c gzipCodec) NewWriter(writer io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriterLevel(writer, c.level)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
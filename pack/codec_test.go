@@ -0,0 +1,136 @@
+/*
+Copyright (C) 2015-2016 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package pack
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+const testCodecID CodecID = 200
+
+type xorCodec struct{}
+
+type xorReader struct {
+	io.Reader
+}
+
+type xorWriter struct {
+	io.Writer
+}
+
+func (r xorReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	for i := range p[:n] {
+		p[i] ^= 0xaa
+	}
+	return n, err
+}
+
+func (w xorWriter) Write(p []byte) (int, error) {
+	buf := make([]byte, len(p))
+	for i, b := range p {
+		buf[i] = b ^ 0xaa
+	}
+	return w.Writer.Write(buf)
+}
+
+func (xorCodec) ID() CodecID {
+	return testCodecID
+}
+
+func (xorCodec) NewReader(reader io.Reader) (io.ReadCloser, error) {
+	return ioutil.NopCloser(xorReader{reader}), nil
+}
+
+func (xorCodec) NewWriter(writer io.Writer) (io.WriteCloser, error) {
+	return nopWriteCloser{xorWriter{writer}}, nil
+}
+
+func init() {
+	RegisterCodec(xorCodec{})
+}
+
+func TestCodecs(t *testing.T) {
+	codecs := []Codec{
+		NewZlibCodec(flate.BestSpeed),
+		NewFlateCodec(flate.BestSpeed),
+		NewFlateCodec(flate.BestCompression),
+		NewGzipCodec(flate.DefaultCompression),
+		noneCodec{},
+		xorCodec{},
+	}
+
+	data := buildTestTree(MipR8G8B8A8UnpackUI32)
+	for _, codec := range codecs {
+		var compressed, decompressed bytes.Buffer
+		if err := CompressTree(bytes.NewReader(data), &compressed, codec); err != nil {
+			panic(err)
+		}
+
+		var header OctreeHeader
+		if err := DecodeHeader(bytes.NewReader(compressed.Bytes()), &header); err != nil {
+			panic(err)
+		}
+
+		if header.Compressed() != (codec.ID() != CodecNone) {
+			panic(fmt.Errorf("codec %v has wrong compression flag", codec.ID()))
+		}
+
+		if header.Compressed() == false {
+			if bytes.Equal(data, compressed.Bytes()) == false {
+				panic("uncompressed copy differs")
+			}
+			continue
+		}
+
+		if header.Codec != codec.ID() {
+			panic(fmt.Errorf("codec %v not recorded in header", codec.ID()))
+		}
+
+		if err := DecompressTree(bytes.NewReader(compressed.Bytes()), &decompressed); err != nil {
+			panic(err)
+		}
+
+		// The codec ID is left in the header as a hint for recompression.
+		output := decompressed.Bytes()
+		if bytes.Equal(data[:7], output[:7]) == false || bytes.Equal(data[8:], output[8:]) == false {
+			panic(fmt.Errorf("codec %v did not round-trip", codec.ID()))
+		}
+	}
+}
+
+func TestUnknownCodec(t *testing.T) {
+	data := buildTestTree(MipR8G8B8A8UnpackUI32)
+
+	var compressed bytes.Buffer
+	if err := CompressTree(bytes.NewReader(data), &compressed, NewFlateCodec(flate.DefaultCompression)); err != nil {
+		panic(err)
+	}
+
+	corrupt := compressed.Bytes()
+	corrupt[7] = 255
+
+	if err := DecompressTree(bytes.NewReader(corrupt), ioutil.Discard); err != errUnknownCodec {
+		panic(fmt.Errorf("expected errUnknownCodec, got %v", err))
+	}
+}
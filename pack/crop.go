@@ -0,0 +1,101 @@
+/*
+Copyright (C) 2015-2016 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package pack
+
+import "io"
+
+func boxOverlaps(b Box, min, max Point) bool {
+	return b.Pos.X < max.X && b.Pos.Y < max.Y && b.Pos.Z < max.Z &&
+		b.Pos.X+b.Size > min.X && b.Pos.Y+b.Size > min.Y && b.Pos.Z+b.Size > min.Z
+}
+
+func boxContains(b Box, min, max Point) bool {
+	return b.Pos.X <= min.X && b.Pos.Y <= min.Y && b.Pos.Z <= min.Z &&
+		b.Pos.X+b.Size >= max.X && b.Pos.Y+b.Size >= max.Y && b.Pos.Z+b.Size >= max.Z
+}
+
+// CropTree writes the nodes of the tree in reader that intersect the
+// world-space box from min to max. The new tree is rooted at the smallest
+// node that encloses the box and records its bounds. Colors of nodes that are
+// only partly inside the box are kept as they are.
+//
+// The output has the format and codec of the input, blocked trees are written
+// as a single compressed stream.
+func CropTree(reader io.ReaderAt, writer io.Writer, min, max Point) error {
+	var (
+		color    Color
+		children [8]uint64
+	)
+
+	tree, err := NewOctreeReader(reader)
+	if err != nil {
+		return err
+	}
+	defer tree.Close()
+
+	header := tree.Header()
+	if header.Bounds.Size <= 0 || header.VoxelsPerAxis == 0 {
+		return errMissingBounds
+	}
+
+	if tree.NumNodes() == 0 || boxOverlaps(header.Bounds, min, max) == false {
+		return errEmptyTree
+	}
+
+	root := subtreeNode{0, header.Bounds, 0}
+	vpa := header.VoxelsPerAxis
+
+	// Re-root at the deepest node that contains the whole box.
+	for vpa > 1 {
+		if err := tree.ReadNode(root.index, &color, nil, children[:]); err != nil {
+			return err
+		}
+
+		next := -1
+		for i := range children {
+			if boxContains(childBox(root.bounds, i), min, max) == true {
+				next = i
+				break
+			}
+		}
+
+		if next < 0 {
+			break
+		}
+
+		if children[next] == 0 {
+			return errEmptyTree
+		}
+
+		root = subtreeNode{children[next], childBox(root.bounds, next), root.depth + 1}
+		vpa /= 2
+	}
+
+	codec, err := inputCodec(reader)
+	if err != nil {
+		return err
+	}
+
+	outputHeader := header
+	outputHeader.Bounds = root.bounds
+	outputHeader.VoxelsPerAxis = vpa
+
+	return writeSubtree(tree, root, outputHeader, codec, writer, func(child subtreeNode) bool {
+		return boxOverlaps(child.bounds, min, max)
+	})
+}
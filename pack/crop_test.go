@@ -0,0 +1,80 @@
+/*
+Copyright (C) 2015-2016 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package pack
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"testing"
+)
+
+func TestCropTree(t *testing.T) {
+	tests := []struct {
+		min, max Point
+		bounds   Box
+		numLeafs uint64
+	}{
+		{Point{0, 0, 0}, Point{80, 80, 80}, Box{Point{0, 0, 0}, 80}, 7},
+		{Point{0, 0, 0}, Point{20, 10, 10}, Box{Point{0, 0, 0}, 20}, 2},
+		{Point{12, 2, 2}, Point{27, 8, 8}, Box{Point{0, 0, 0}, 40}, 2},
+		{Point{40.5, 10.5, 0.5}, Point{49.5, 19.5, 9.5}, Box{Point{40, 10, 0}, 10}, 1},
+	}
+
+	for _, format := range []OctreeFormat{MipR8G8B8A8UnpackUI32, MipR8G8B8MaskUI32, MipR5G6B5PackRelUI30} {
+		var built, compressed bytes.Buffer
+
+		cfg := testBuildConfig(format, &built)
+		cfg.Optimize = false
+		if _, err := BuildTree(&cfg); err != nil {
+			panic(err)
+		}
+
+		if err := CompressTree(bytes.NewReader(built.Bytes()), &compressed, NewZlibCodec(zlib.DefaultCompression)); err != nil {
+			panic(err)
+		}
+
+		for _, test := range tests {
+			for n, data := range [][]byte{built.Bytes(), compressed.Bytes()} {
+				var cropped bytes.Buffer
+				if err := CropTree(bytes.NewReader(data), &cropped, test.min, test.max); err != nil {
+					panic(err)
+				}
+
+				report, err := Validate(bytes.NewReader(cropped.Bytes()))
+				if err != nil {
+					panic(err)
+				}
+
+				header := report.Header
+				if report.Valid() == false || header.Format != format || header.Compressed() != (n == 1) {
+					panic(fmt.Errorf("invalid cropped tree in format %v: %+v", format, report))
+				}
+
+				if header.Bounds != test.bounds || header.NumLeafs != test.numLeafs || float64(header.VoxelsPerAxis) != test.bounds.Size/10 {
+					panic(fmt.Errorf("crop %v-%v: %v leafs, %v bounds, %v voxels per axis", test.min, test.max, header.NumLeafs, header.Bounds, header.VoxelsPerAxis))
+				}
+			}
+		}
+
+		var cropped bytes.Buffer
+		if err := CropTree(bytes.NewReader(built.Bytes()), &cropped, Point{60, 60, 60}, Point{70, 70, 70}); err != errEmptyTree {
+			panic("expected errEmptyTree")
+		}
+	}
+}
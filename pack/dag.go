@@ -0,0 +1,236 @@
+/*
+Copyright (C) 2015-2016 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package pack
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// dagKey identifies a subtree by its root, with the color and attributes
+// quantized to the output format, and the positions of its already merged
+// children.
+type dagKey struct {
+	Color    Color
+	Attr     Attributes
+	Children [8]uint64
+}
+
+type dagBuilder struct {
+	tree     *OctreeReader
+	header   *OctreeHeader
+	unique   map[dagKey]uint64
+	file     *os.File
+	writer   *bufio.Writer
+	numNodes uint64
+	numLeafs uint64
+	scratch  bytes.Buffer
+}
+
+// DeduplicateTree merges identical subtrees of the tree in reader and writes
+// the result as a directed acyclic graph in format. Colors are compared after
+// quantization to format, so subtrees that only differ below the precision of
+// the format are merged as well.
+//
+// The merged nodes are kept in a temporary file in post-order, only a fixed
+// size key per unique node is held in memory.
+func DeduplicateTree(reader io.ReaderAt, writer io.Writer, format OctreeFormat) (OptStatus, error) {
+	var status OptStatus
+
+	// Shared children can not be stored contiguously.
+	if format.childMask() == true {
+		return status, errUnsupportedFormat
+	}
+
+	tree, err := NewOctreeReader(reader)
+	if err != nil {
+		return status, err
+	}
+	defer tree.Close()
+
+	header := tree.Header()
+	header.Version = binaryVersion
+	header.Format = format
+	header.Flags |= dagMask
+	header.Flags &^= blockedMask
+	header.BlockSize = 0
+	header.FarPointers = nil
+
+	if format.Paletted() == true {
+		if header.Palette, err = treePalette(tree); err != nil {
+			return status, err
+		}
+	}
+
+	fp, err := ioutil.TempFile("", "")
+	if err != nil {
+		return status, err
+	}
+	defer removeTempFile(fp)
+
+	dag := dagBuilder{tree: tree, header: &header, unique: make(map[dagKey]uint64), file: fp, writer: bufio.NewWriter(fp)}
+	if _, err := dag.insert(0); err != nil {
+		return status, err
+	}
+
+	if err := dag.writer.Flush(); err != nil {
+		return status, err
+	}
+	dag.unique = nil
+
+	numNodes := dag.numNodes
+	header.NumNodes = numNodes
+	header.NumLeafs = dag.numLeafs
+	status.DedupRatio = float64(tree.NumNodes()) / float64(numNodes)
+
+	// Nodes are stored after their children, they are written in reverse to
+	// put the root first.
+	output := func(key *dagKey) {
+		for j, child := range key.Children {
+			if child > 0 {
+				key.Children[j] = numNodes - child
+			}
+		}
+	}
+
+	if format.Relative() == true {
+		table := make(farTable)
+		err := dag.readNodes(func(pos uint64, key *dagKey) error {
+			output(key)
+			table.add(format, numNodes-1-pos, key.Children[:])
+			return nil
+		})
+		if err != nil {
+			return status, err
+		}
+		header.FarPointers = table.pointers()
+	}
+
+	if err := EncodeHeader(writer, header); err != nil {
+		return status, err
+	}
+
+	nodeWriter, err := NewNodeWriter(writer, &header)
+	if err != nil {
+		return status, err
+	}
+
+	err = dag.readNodes(func(pos uint64, key *dagKey) error {
+		output(key)
+		return EncodeNode(nodeWriter, &header, numNodes-1-pos, key.Color, &key.Attr, key.Children[:])
+	})
+	if err != nil {
+		nodeWriter.Close()
+		return status, err
+	}
+
+	return status, nodeWriter.Close()
+}
+
+// readNodes calls fn with the merged nodes from the last to the first.
+func (dag *dagBuilder) readNodes(fn func(pos uint64, key *dagKey) error) error {
+	var key dagKey
+
+	size := int64(binary.Size(key))
+	record := make([]byte, size)
+
+	for pos := dag.numNodes; pos > 0; pos-- {
+		if _, err := dag.file.ReadAt(record, int64(pos-1)*size); err != nil {
+			return err
+		}
+
+		if err := binary.Read(bytes.NewReader(record), binary.LittleEndian, &key); err != nil {
+			return err
+		}
+
+		if err := fn(pos-1, &key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// quantize returns the color and attributes of a node as they are stored in
+// the output format.
+func (dag *dagBuilder) quantize(color Color, attr *Attributes) (Color, Attributes, error) {
+	var (
+		empty [8]uint64
+		c     Color
+		a     Attributes
+	)
+
+	dag.scratch.Reset()
+	if err := EncodeNode(&dag.scratch, dag.header, 0, color, attr, empty[:]); err != nil {
+		return c, a, err
+	}
+
+	err := DecodeNode(&dag.scratch, dag.header, 0, &c, &a, empty[:])
+	return c, a, err
+}
+
+// insert adds the subtree at index and returns the position of its root among
+// the merged nodes. Children are referred to by their position plus one.
+func (dag *dagBuilder) insert(index uint64) (uint64, error) {
+	var (
+		key   dagKey
+		color Color
+		attr  Attributes
+	)
+
+	if err := dag.tree.ReadNode(index, &color, &attr, key.Children[:]); err != nil {
+		return 0, err
+	}
+
+	isLeaf := true
+	for i, child := range key.Children {
+		if child > 0 {
+			pos, err := dag.insert(child)
+			if err != nil {
+				return 0, err
+			}
+			key.Children[i] = pos + 1
+			isLeaf = false
+		}
+	}
+
+	var err error
+	if key.Color, key.Attr, err = dag.quantize(color, &attr); err != nil {
+		return 0, err
+	}
+
+	if pos, ok := dag.unique[key]; ok == true {
+		return pos, nil
+	}
+
+	if err := binary.Write(dag.writer, binary.LittleEndian, &key); err != nil {
+		return 0, err
+	}
+
+	pos := dag.numNodes
+	dag.unique[key] = pos
+	dag.numNodes++
+
+	if isLeaf == true {
+		dag.numLeafs++
+	}
+	return pos, nil
+}
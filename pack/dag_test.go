@@ -0,0 +1,162 @@
+/*
+Copyright (C) 2015-2016 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package pack
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func testSameLeafs(a, b *OctreeReader, ia, ib uint64) {
+	var (
+		ca, cb   Color
+		cha, chb [8]uint64
+	)
+
+	if err := a.ReadNode(ia, &ca, nil, cha[:]); err != nil {
+		panic(err)
+	}
+
+	if err := b.ReadNode(ib, &cb, nil, chb[:]); err != nil {
+		panic(err)
+	}
+
+	if ca != cb {
+		panic(fmt.Errorf("node %v and %v differ in color", ia, ib))
+	}
+
+	for i := range cha {
+		if (cha[i] == 0) != (chb[i] == 0) {
+			panic(fmt.Errorf("node %v and %v differ in children", ia, ib))
+		}
+
+		if cha[i] > 0 {
+			testSameLeafs(a, b, uint64(cha[i]), uint64(chb[i]))
+		}
+	}
+}
+
+func TestDeduplicateTree(t *testing.T) {
+	for _, format := range testFormats {
+		var buffer bytes.Buffer
+
+		data := buildTestTree(format)
+		_, err := DeduplicateTree(bytes.NewReader(data), &buffer, format)
+		if format.childMask() == true {
+			if err != errUnsupportedFormat {
+				panic("expected errUnsupportedFormat")
+			}
+			continue
+		} else if err != nil {
+			panic(err)
+		}
+
+		report, err := Validate(bytes.NewReader(buffer.Bytes()))
+		if err != nil {
+			panic(err)
+		}
+
+		if report.Valid() == false || report.Header.DAG() == false {
+			panic(fmt.Errorf("format %v: %+v", format, report))
+		}
+
+		tree, err := NewOctreeReader(bytes.NewReader(data))
+		if err != nil {
+			panic(err)
+		}
+
+		dag, err := NewOctreeReader(bytes.NewReader(buffer.Bytes()))
+		if err != nil {
+			panic(err)
+		}
+
+		testSameLeafs(tree, dag, 0, 0)
+		tree.Close()
+		dag.Close()
+	}
+}
+
+func TestDeduplicateSharedSubtrees(t *testing.T) {
+	var buffer bytes.Buffer
+
+	header := OctreeHeader{Sign: octreeSignature, Version: binaryVersion, Format: MipR8G8B8A8UnpackUI32, NumLeafs: 4}
+	nodes := [][8]uint64{
+		{1, 2},
+		{3, 4},
+		{5, 6},
+		{}, {}, {}, {},
+	}
+
+	status, err := DeduplicateTree(bytes.NewReader(encodeTestNodes(header, nodes)), &buffer, MipR8G8B8A8UnpackUI32)
+	if err != nil {
+		panic(err)
+	}
+
+	header, _, children := decodeTestTree(buffer.Bytes())
+	if header.NumNodes != 3 || header.NumLeafs != 1 || status.DedupRatio != 7.0/3 {
+		panic(fmt.Errorf("unexpected dag: %+v, %+v", header, status))
+	}
+
+	if children[0] != [8]uint64{1, 1} || children[1] != [8]uint64{2, 2} {
+		panic(fmt.Errorf("unexpected children: %v", children))
+	}
+}
+
+func TestDeduplicateQuantized(t *testing.T) {
+	var tree, buffer bytes.Buffer
+
+	// The leafs only differ below the precision of R5G6B5.
+	header := OctreeHeader{Format: MipR8G8B8A8UnpackUI32, VoxelsPerAxis: 2}
+	w, err := NewOctreeWriter(&tree, header, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	nodes := []struct {
+		color    Color
+		children [8]uint64
+	}{
+		{Color{1, 1, 1, 1}, [8]uint64{1, 2, 3}},
+		{Color{1, 0, 0, 1}, [8]uint64{}},
+		{Color{1, 2.0 / 255, 0, 1}, [8]uint64{}},
+		{Color{0, 0, 1, 1}, [8]uint64{}},
+	}
+
+	for _, node := range nodes {
+		if err := w.WriteNode(node.color, nil, node.children[:]); err != nil {
+			panic(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+
+	if _, err := DeduplicateTree(bytes.NewReader(tree.Bytes()), &buffer, MipR5G6B5UnpackUI16); err != nil {
+		panic(err)
+	}
+
+	h, colors, children := decodeTestTree(buffer.Bytes())
+	if h.NumNodes != 3 || h.NumLeafs != 2 || children[0][0] != children[0][1] || children[0][0] == children[0][2] {
+		panic(fmt.Errorf("unexpected dag: %+v, %v", h, children))
+	}
+
+	if colors[children[0][2]] != (Color{0, 0, 1, 1}) {
+		panic(fmt.Errorf("unexpected leaf color: %v", colors[children[0][2]]))
+	}
+}
@@ -20,9 +20,32 @@ package pack
 import "errors"
 
 var (
-	errUnsupportedFormat = errors.New("unsupported octree-format")
-	errInvalidFile       = errors.New("invalid file")
-	errOctreeOverflow    = errors.New("octree-format overflow")
-	errVoxelsPowerOfTwo  = errors.New("voxels must be a power of two")
-	errInputIsCompressed = errors.New("input is compressed")
+	errUnsupportedFormat   = errors.New("unsupported octree-format")
+	errInvalidFile         = errors.New("invalid file")
+	errOctreeOverflow      = errors.New("octree-format overflow")
+	errVoxelsPowerOfTwo    = errors.New("voxels must be a power of two")
+	errInputIsCompressed   = errors.New("input is compressed")
+	errInputIsUncompressed = errors.New("input is not compressed")
+	errNodeOutOfRange      = errors.New("node index out of range")
+	errUnsupportedVersion  = errors.New("unsupported octree-version")
+	errInvalidSignature    = errors.New("invalid signature")
+	errUnknownCodec        = errors.New("unknown compression codec")
+	errBlockSize           = errors.New("invalid block size")
+	errBlockTable          = errors.New("invalid block table")
+	errBlockedFlag         = errors.New("blocked flag set on uncompressed tree")
+	errUnknownFlags        = errors.New("unknown flags are set")
+	errFilterFlag          = errors.New("filter flag set on unoptimized tree")
+	errEmptyTree           = errors.New("tree has no root node")
+	errNumLeafs            = errors.New("number of leafs does not match header")
+	errUnknownChannels     = errors.New("unknown attribute channels")
+	errNotContiguous       = errors.New("children are not stored contiguously")
+	errTreeLayout          = errors.New("tree has shared or unreachable nodes")
+	errFarPointer          = errors.New("invalid far pointer")
+	errTrailer             = errors.New("could not read tree trailer")
+	errTrailerSeek         = errors.New("tree trailer can only be read from a seekable reader")
+	errMetadata            = errors.New("invalid metadata section")
+	errMissingBounds       = errors.New("tree has no bounds")
+	errVoxelsPerAxis       = errors.New("voxels per axis exceeds the tree")
+	errTransform           = errors.New("trees have different transforms")
+	errInvertTransform     = errors.New("transform can not be inverted")
 )
@@ -0,0 +1,203 @@
+/*
+Copyright (C) 2015-2016 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package pack
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// PointFormat is a point cloud file format written by ExportPoints.
+type PointFormat int
+
+const (
+	// PointsXYZ writes "X Y Z INTENSITY R G B" lines, as read by packer.
+	PointsXYZ PointFormat = iota
+	PointsPLYASCII
+	PointsPLYBinary
+	PointsPCDASCII
+	PointsPCDBinary
+)
+
+type exportPoint struct {
+	pos   Point
+	color [3]byte
+	attr  Attributes
+}
+
+func colorByte(c float32) byte {
+	return byte(math.Max(0, math.Min(255, float64(c)*255+0.5)))
+}
+
+// walkPoints calls fn with the center of every leaf of tree, or of every node
+// at depth if depth is not negative. Leafs above depth are included. The
+// points are moved back to source coordinates by the inverse transform of
+// the tree.
+func walkPoints(tree *OctreeReader, depth int, fn func(p *exportPoint) error) error {
+	inverse, err := inverseTransform(tree.Header().Transform)
+	if err != nil {
+		return err
+	}
+
+	var p exportPoint
+	return walkTree(tree, tree.Header().Bounds, func(index uint64, bounds Box, d int, color Color, attr *Attributes, children []uint64) (bool, error) {
+		if d != depth && noChildren(children) == false {
+			return true, nil
+		}
+
+		half := bounds.Size * 0.5
+		center := Point{bounds.Pos.X + half, bounds.Pos.Y + half, bounds.Pos.Z + half}
+		p.pos = center.transform(&inverse)
+		p.color = [3]byte{colorByte(color.R), colorByte(color.G), colorByte(color.B)}
+		p.attr = *attr
+		return false, fn(&p)
+	})
+}
+
+// ExportPoints writes a point at the center of every leaf of the tree in
+// reader, with its decoded color, and returns the number of points. If depth
+// is not negative the nodes at that depth are written instead of the leafs
+// below them.
+//
+// The points are written in the coordinates of the source cloud. The tree
+// stores them after its transform, such as the packer -rotate and -translate
+// options, so the inverse of the header transform is applied to every point.
+func ExportPoints(reader io.ReaderAt, writer io.Writer, format PointFormat, depth int) (uint64, error) {
+	tree, err := NewOctreeReader(reader)
+	if err != nil {
+		return 0, err
+	}
+	defer tree.Close()
+
+	header := tree.Header()
+	if header.Bounds.Size <= 0 {
+		return 0, errMissingBounds
+	}
+
+	// PLY and PCD need the number of points up front.
+	var numPoints uint64
+	if format != PointsXYZ {
+		err := walkPoints(tree, depth, func(p *exportPoint) error {
+			numPoints++
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	buffer := bufio.NewWriter(writer)
+	intensity := header.Channels.Has(ChannelIntensity)
+
+	var write func(p *exportPoint) error
+	switch format {
+	case PointsXYZ:
+		write = func(p *exportPoint) error {
+			_, err := fmt.Fprintf(buffer, "%v %v %v %v %v %v %v\n", p.pos.X, p.pos.Y, p.pos.Z, p.attr.Intensity, p.color[0], p.color[1], p.color[2])
+			return err
+		}
+	case PointsPLYASCII:
+		if err := writePLYHeader(buffer, "ascii", numPoints, intensity); err != nil {
+			return 0, err
+		}
+		write = func(p *exportPoint) error {
+			if _, err := fmt.Fprintf(buffer, "%v %v %v %v %v %v", p.pos.X, p.pos.Y, p.pos.Z, p.color[0], p.color[1], p.color[2]); err != nil {
+				return err
+			}
+			if intensity == true {
+				if _, err := fmt.Fprintf(buffer, " %v", p.attr.Intensity); err != nil {
+					return err
+				}
+			}
+			_, err := fmt.Fprintln(buffer)
+			return err
+		}
+	case PointsPLYBinary:
+		if err := writePLYHeader(buffer, "binary_little_endian", numPoints, intensity); err != nil {
+			return 0, err
+		}
+		write = func(p *exportPoint) error {
+			if err := binary.Write(buffer, binary.LittleEndian, p.pos); err != nil {
+				return err
+			}
+			if err := binary.Write(buffer, binary.LittleEndian, p.color); err != nil {
+				return err
+			}
+			if intensity == true {
+				return binary.Write(buffer, binary.LittleEndian, p.attr.Intensity)
+			}
+			return nil
+		}
+	case PointsPCDASCII:
+		if err := writePCDHeader(buffer, "ascii", numPoints, intensity); err != nil {
+			return 0, err
+		}
+		write = func(p *exportPoint) error {
+			if _, err := fmt.Fprintf(buffer, "%v %v %v %v", float32(p.pos.X), float32(p.pos.Y), float32(p.pos.Z), pcdColor(p.color)); err != nil {
+				return err
+			}
+			if intensity == true {
+				if _, err := fmt.Fprintf(buffer, " %v", p.attr.Intensity); err != nil {
+					return err
+				}
+			}
+			_, err := fmt.Fprintln(buffer)
+			return err
+		}
+	case PointsPCDBinary:
+		if err := writePCDHeader(buffer, "binary", numPoints, intensity); err != nil {
+			return 0, err
+		}
+		write = func(p *exportPoint) error {
+			point := [4]float32{float32(p.pos.X), float32(p.pos.Y), float32(p.pos.Z), pcdColor(p.color)}
+			if err := binary.Write(buffer, binary.LittleEndian, point); err != nil {
+				return err
+			}
+			if intensity == true {
+				return binary.Write(buffer, binary.LittleEndian, p.attr.Intensity)
+			}
+			return nil
+		}
+	default:
+		return 0, errUnsupportedFormat
+	}
+
+	var written uint64
+	err = walkPoints(tree, depth, func(p *exportPoint) error {
+		written++
+		return write(p)
+	})
+	if err != nil {
+		return written, err
+	}
+
+	return written, buffer.Flush()
+}
+
+func writePLYHeader(writer io.Writer, format string, numPoints uint64, intensity bool) error {
+	properties := "property double x\nproperty double y\nproperty double z\nproperty uchar red\nproperty uchar green\nproperty uchar blue\n"
+	if intensity == true {
+		properties += "property float intensity\n"
+	}
+
+	_, err := fmt.Fprintf(writer, "ply\nformat %v 1.0\ncomment octatron\nelement vertex %v\n%vend_header\n", format, numPoints, properties)
+	return err
+}
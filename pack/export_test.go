@@ -0,0 +1,183 @@
+/*
+Copyright (C) 2015-2016 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package pack
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestExportPoints(t *testing.T) {
+	var built bytes.Buffer
+
+	cfg := testBuildConfig(MipR8G8B8A8UnpackUI32, &built)
+	cfg.Optimize = false
+	if _, err := BuildTree(&cfg); err != nil {
+		panic(err)
+	}
+
+	var xyz bytes.Buffer
+	n, err := ExportPoints(bytes.NewReader(built.Bytes()), &xyz, PointsXYZ, -1)
+	if err != nil {
+		panic(err)
+	}
+
+	var (
+		p       Point
+		r, g, b byte
+		ref     float32
+	)
+
+	points := make(map[Point]Color)
+	scanner := bufio.NewScanner(&xyz)
+	for scanner.Scan() {
+		if _, err := fmt.Sscan(scanner.Text(), &p.X, &p.Y, &p.Z, &ref, &r, &g, &b); err != nil {
+			panic(err)
+		}
+		points[p] = Color{float32(r) / 255, float32(g) / 255, float32(b) / 255, 1}
+	}
+
+	if n != 7 || len(points) != 7 {
+		panic(fmt.Errorf("exported %v points, expected 7", n))
+	}
+
+	// Every sample of the test tree is in a voxel of its own.
+	samples := make(chan Sample, 16)
+	if err := testSampleWorker(samples); err != nil {
+		panic(err)
+	}
+	close(samples)
+
+	for s := range samples {
+		center := Point{math.Floor(s.Pos.X/10)*10 + 5, math.Floor(s.Pos.Y/10)*10 + 5, math.Floor(s.Pos.Z/10)*10 + 5}
+		if points[center] != s.Col {
+			panic(fmt.Errorf("point at %v has color %v, expected %v", center, points[center], s.Col))
+		}
+	}
+
+	for _, depth := range []int{0, 1, 2, 3, 4} {
+		var ascii, binary bytes.Buffer
+
+		expected := []uint64{1, 2, 5, 7, 7}[depth]
+		if n, err := ExportPoints(bytes.NewReader(built.Bytes()), &ascii, PointsPLYASCII, depth); err != nil || n != expected {
+			panic(fmt.Errorf("exported %v points at depth %v, expected %v: %v", n, depth, expected, err))
+		}
+
+		if n, err := ExportPoints(bytes.NewReader(built.Bytes()), &binary, PointsPLYBinary, depth); err != nil || n != expected {
+			panic(fmt.Errorf("exported %v points at depth %v, expected %v: %v", n, depth, expected, err))
+		}
+
+		header := fmt.Sprintf("element vertex %v\n", expected)
+		asciiText, binaryText := ascii.String(), binary.String()
+		if strings.Contains(asciiText, header) == false || strings.Contains(binaryText, header) == false {
+			panic("invalid vertex count")
+		}
+
+		end := strings.Index(asciiText, "end_header\n") + len("end_header\n")
+		if strings.Count(asciiText[end:], "\n") != int(expected) {
+			panic("invalid ascii ply")
+		}
+
+		end = strings.Index(binaryText, "end_header\n") + len("end_header\n")
+		if len(binaryText)-end != int(expected)*(3*8+3) {
+			panic("invalid binary ply")
+		}
+	}
+}
+
+func TestExportTransform(t *testing.T) {
+	// Rotation of 90 degrees around Y and a translation, as packer stores
+	// the -rotate and -translate options.
+	transform := [16]float64{0, 0, -1, 0, 0, 1, 0, 0, 1, 0, 0, 0, 100, 0, 0, 1}
+
+	var built bytes.Buffer
+	cfg := testBuildConfig(MipR8G8B8A8UnpackUI32, &built)
+	cfg.Bounds = Box{Point{100, 0, -80}, 80}
+	cfg.Transform = transform
+	cfg.Worker = func(samples chan<- Sample) error {
+		source := make(chan Sample, 16)
+		if err := testSampleWorker(source); err != nil {
+			return err
+		}
+		close(source)
+
+		for s := range source {
+			s.Pos = s.Pos.transform(&transform)
+			samples <- s
+		}
+		return nil
+	}
+
+	if _, err := BuildTree(&cfg); err != nil {
+		panic(err)
+	}
+
+	var xyz bytes.Buffer
+	if _, err := ExportPoints(bytes.NewReader(built.Bytes()), &xyz, PointsXYZ, -1); err != nil {
+		panic(err)
+	}
+
+	var (
+		p       Point
+		ref     float32
+		r, g, b byte
+	)
+
+	points := make(map[Point]bool)
+	scanner := bufio.NewScanner(&xyz)
+	for scanner.Scan() {
+		if _, err := fmt.Sscan(scanner.Text(), &p.X, &p.Y, &p.Z, &ref, &r, &g, &b); err != nil {
+			panic(err)
+		}
+		points[Point{math.Round(p.X), math.Round(p.Y), math.Round(p.Z)}] = true
+	}
+
+	samples := make(chan Sample, 16)
+	if err := testSampleWorker(samples); err != nil {
+		panic(err)
+	}
+	close(samples)
+
+	for s := range samples {
+		center := Point{math.Floor(s.Pos.X/10)*10 + 5, math.Floor(s.Pos.Y/10)*10 + 5, math.Floor(s.Pos.Z/10)*10 + 5}
+		if points[center] == false {
+			panic(fmt.Errorf("no point exported at source position %v", center))
+		}
+	}
+
+	m := [16]float64{2, 1, 0, 0, 0, 3, 1, 0, 1, 0, 4, 0, -5, 7, 9, 1}
+	inverse, err := inverseTransform(m)
+	if err != nil {
+		panic(err)
+	}
+
+	q := Point{1.5, -2, 3}
+	transformed := q.transform(&m)
+	back := transformed.transform(&inverse)
+	if math.Abs(back.X-q.X)+math.Abs(back.Y-q.Y)+math.Abs(back.Z-q.Z) > 1e-9 {
+		panic(fmt.Errorf("inverse transform gave %v, expected %v", back, q))
+	}
+
+	if _, err := inverseTransform([16]float64{15: 1}); err != errInvertTransform {
+		panic("inverted a singular transform")
+	}
+}
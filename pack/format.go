@@ -18,7 +18,6 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>.
 package pack
 
 import (
-	"compress/zlib"
 	"encoding/binary"
 	"io"
 	"math"
@@ -37,8 +36,24 @@ const (
 	MipR5G6B5PackUI30
 	MipR3G3B2PackUI31
 
+	// Child-mask formats store a bit per existing child and the index of
+	// the first child, the children of a node are stored contiguously.
+	MipR8G8B8MaskUI32
+
+	// Palette-indexed formats store an index into the palette of the header.
+	MipP8PackUI31
+
+	// Wide formats for trees with more than 2^32 nodes.
+	MipR8G8B8A8UnpackUI64
+
+	// Relative formats store children as an offset from their parent.
+	MipR8G8B8A8PackRelUI28
+	MipR4G4B4A4PackRelUI30
+	MipR5G6B5PackRelUI30
+	MipR3G3B2PackRelUI31
+
 	// Internal formats
-	mipR64G64B64A64S64UnpackUI32
+	mipR64G64B64A64S64UnpackUI64
 )
 
 const (
@@ -48,8 +63,8 @@ const (
 )
 
 var (
-	formatColorSize = [...]int{4, 4, 2, 2, 0, 0, 0, 0, 40}
-	formatIndexSize = [...]int{4, 2, 2, 2, 4, 4, 4, 4, 4}
+	formatColorSize = [...]int{4, 4, 2, 2, 0, 0, 0, 0, 3, 0, 4, 0, 0, 0, 0, 40}
+	formatIndexSize = [...]int{4, 2, 2, 2, 4, 4, 4, 4, 4, 4, 8, 4, 4, 4, 4, 8}
 )
 
 func (f OctreeFormat) IndexSize() int {
@@ -61,35 +76,118 @@ func (f OctreeFormat) ColorSize() int {
 }
 
 func (f OctreeFormat) NodeSize() int {
+	if f.childMask() == true {
+		return formatColorSize[f] + 1 + formatIndexSize[f]
+	}
 	return formatColorSize[f] + formatIndexSize[f]*8
 }
 
+func (f OctreeFormat) childMask() bool {
+	return f == MipR8G8B8MaskUI32
+}
+
+// Paletted reports whether the header of a tree in this format holds a
+// palette.
+func (f OctreeFormat) Paletted() bool {
+	return f == MipP8PackUI31
+}
+
 const (
-	binaryVersion  byte = 0x0
+	binaryVersion  byte = 0x4
 	endianMask     byte = 0x1
 	compressedMask byte = 0x2
 	optimizedMask  byte = 0x4
+	filteredMask   byte = 0x8
+	blockedMask    byte = 0x10
+	dagMask        byte = 0x20
+	trailerMask    byte = 0x40
 )
 
+var octreeSignature = [4]byte{0x1b, 0x6f, 0x63, 0x74}
+
 type OctreeHeader struct {
 	Sign          [4]byte
 	Version       byte
 	Format        OctreeFormat
 	Flags         byte
-	Unused        byte
+	Codec         CodecID
 	NumNodes      uint64
 	NumLeafs      uint64
 	VoxelsPerAxis uint32
+
+	// Version 1
+	Bounds         Box
+	Transform      [16]float64
+	ColorThreshold float32
+
+	// Version 2
+	BlockSize uint32
+
+	// Version 3
+	Channels Channels
+
+	// Paletted formats
+	Palette Palette
+
+	// Relative formats, sorted
+	FarPointers []uint64
+
+	// Version 4, see Metadata
+	Metadata []byte
+}
+
+func (h *OctreeHeader) baseFields() []interface{} {
+	return []interface{}{&h.Sign, &h.Version, &h.Format, &h.Flags, &h.Codec, &h.NumNodes, &h.NumLeafs, &h.VoxelsPerAxis}
+}
+
+func (h *OctreeHeader) versionFields() []interface{} {
+	var fields []interface{}
+	if h.Version >= 1 {
+		fields = append(fields, &h.Bounds, &h.Transform, &h.ColorThreshold)
+	}
+	if h.Version >= 2 {
+		fields = append(fields, &h.BlockSize)
+	}
+	if h.Version >= 3 {
+		fields = append(fields, &h.Channels)
+	}
+	if h.Format.Paletted() == true {
+		fields = append(fields, &h.Palette)
+	}
+	return fields
+}
+
+func (h *OctreeHeader) fieldsSize() int {
+	size := 0
+	for _, field := range append(h.baseFields(), h.versionFields()...) {
+		size += binary.Size(field)
+	}
+	return size
 }
 
 func (h *OctreeHeader) Size() int {
-	return 28
+	return h.fieldsSize() + h.farTableSize() + h.metadataSize()
 }
 
 func (h *OctreeHeader) BigEndian() bool {
 	return h.Flags&endianMask == endianMask
 }
 
+func (h *OctreeHeader) ByteOrder() binary.ByteOrder {
+	if h.BigEndian() == true {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
+
+func (h *OctreeHeader) SetByteOrder(order binary.ByteOrder) {
+	if order == binary.BigEndian {
+		h.Flags |= endianMask
+	} else {
+		h.Flags &^= endianMask
+	}
+}
+
 func (h *OctreeHeader) Compressed() bool {
 	return h.Flags&compressedMask == compressedMask
 }
@@ -98,62 +196,250 @@ func (h *OctreeHeader) Optimized() bool {
 	return h.Flags&optimizedMask == optimizedMask
 }
 
-func TranscodeTree(reader io.Reader, writer io.Writer, format OctreeFormat) error {
+func (h *OctreeHeader) Filtered() bool {
+	return h.Flags&filteredMask == filteredMask
+}
+
+// Blocked reports whether the node data is split in independently compressed
+// blocks of BlockSize nodes.
+func (h *OctreeHeader) Blocked() bool {
+	return h.Flags&blockedMask == blockedMask
+}
+
+// DAG reports whether nodes may be shared by several parents, as in the
+// output of DeduplicateTree.
+func (h *OctreeHeader) DAG() bool {
+	return h.Flags&dagMask == dagMask
+}
+
+func TranscodeTree(reader io.Reader, writer io.Writer, format OctreeFormat, order binary.ByteOrder) error {
+	var inputHeader, outputHeader OctreeHeader
+
+	if err := DecodeHeader(reader, &inputHeader); err != nil {
+		return err
+	}
+
+	outputHeader = inputHeader
+	outputHeader.Format = format
+	outputHeader.SetByteOrder(order)
+
+	// Blocked trees need a seekable writer, fall back to a single stream.
+	outputHeader.Flags &^= blockedMask
+	outputHeader.BlockSize = 0
+	outputHeader.FarPointers = nil
+
+	if format.childMask() == true || format.Paletted() == true || format.Relative() == true {
+		return transcodeTempFile(reader, writer, inputHeader, outputHeader)
+	}
+
+	codec, err := outputHeader.codec()
+	if err != nil {
+		return err
+	}
+
+	return recodeNodes(reader, writer, inputHeader, outputHeader, codec)
+}
+
+// transcodeTempFile transcodes through a temporary copy of the tree, for
+// formats that need to see every node before the first one is written.
+func transcodeTempFile(reader io.Reader, writer io.Writer, inputHeader, outputHeader OctreeHeader) error {
+	fp, err := plainTempFile(reader, inputHeader)
+	if err != nil {
+		return err
+	}
+	defer removeTempFile(fp)
+
+	tree, err := NewOctreeReader(fp)
+	if err != nil {
+		return err
+	}
+	defer tree.Close()
+
+	if outputHeader.Format.Paletted() == true {
+		if outputHeader.Palette, err = treePalette(tree); err != nil {
+			return err
+		}
+	}
+
+	if outputHeader.Format.Relative() == true {
+		if outputHeader.FarPointers, err = treeFarPointers(tree, outputHeader.Format); err != nil {
+			return err
+		}
+	}
+
+	if outputHeader.Format.childMask() == true {
+		return relayoutTree(tree, writer, outputHeader)
+	}
+
+	if _, err := fp.Seek(int64(tree.header.Size()), 0); err != nil {
+		return err
+	}
+
+	codec, err := outputHeader.codec()
+	if err != nil {
+		return err
+	}
+
+	return recodeNodes(fp, writer, tree.header, outputHeader, codec)
+}
+
+// recodeNodes writes outputHeader and then every node that follows the
+// already decoded inputHeader in reader, compressed with codec.
+func recodeNodes(reader io.Reader, writer io.Writer, inputHeader, outputHeader OctreeHeader, codec Codec) error {
 	var (
-		header   OctreeHeader
 		color    Color
-		children [8]uint32
+		attr     Attributes
+		children [8]uint64
 	)
 
-	if err := binary.Read(reader, binary.LittleEndian, &header); err != nil {
+	if err := EncodeHeader(writer, outputHeader); err != nil {
 		return err
 	}
 
-	inputFormat := header.Format
-	header.Format = format
+	nodeReader, err := NewNodeReader(reader, &inputHeader)
+	if err != nil {
+		return err
+	}
+	defer nodeReader.Close()
 
-	if err := binary.Write(writer, binary.LittleEndian, header); err != nil {
+	nodeWriter, err := codec.NewWriter(writer)
+	if err != nil {
 		return err
 	}
 
-	if header.Compressed() == true {
-		readCloser, err := zlib.NewReader(reader)
+	for i := uint64(0); i < inputHeader.NumNodes; i++ {
+		if err := DecodeNode(nodeReader, &inputHeader, i, &color, &attr, children[:]); err != nil {
+			nodeWriter.Close()
+			return err
+		}
+
+		if err := EncodeNode(nodeWriter, &outputHeader, i, color, &attr, children[:]); err != nil {
+			nodeWriter.Close()
+			return err
+		}
+	}
+
+	return nodeWriter.Close()
+}
+
+func (h *OctreeHeader) codec() (Codec, error) {
+	if h.Compressed() == false {
+		return noneCodec{}, nil
+	}
+	return LookupCodec(h.Codec)
+}
+
+// NewNodeReader returns a reader for the node data that follows header,
+// decompressing it with the header's codec if the tree is compressed.
+func NewNodeReader(reader io.Reader, header *OctreeHeader) (io.ReadCloser, error) {
+	codec, err := header.codec()
+	if err != nil {
+		return nil, err
+	}
+
+	if header.Blocked() == true {
+		offsets, err := readBlockTable(reader, header)
 		if err != nil {
+			return nil, err
+		}
+		return &blockReader{reader: reader, codec: codec, offsets: offsets}, nil
+	}
+
+	return codec.NewReader(reader)
+}
+
+// NewNodeWriter returns a writer for the node data that follows header,
+// compressing it with the header's codec if the tree is compressed. The
+// writer must be closed to flush any buffered data.
+func NewNodeWriter(writer io.Writer, header *OctreeHeader) (io.WriteCloser, error) {
+	codec, err := header.codec()
+	if err != nil {
+		return nil, err
+	}
+	return codec.NewWriter(writer)
+}
+
+func DecodeHeader(reader io.Reader, header *OctreeHeader) error {
+	*header = OctreeHeader{}
+
+	// The flags are stored in a single byte ahead of any multi-byte field,
+	// so the byte order is known by the time it is needed.
+	for _, field := range header.baseFields() {
+		if err := binary.Read(reader, header.ByteOrder(), field); err != nil {
 			return err
 		}
-		defer readCloser.Close()
-		reader = readCloser
+	}
 
-		writeCloser := zlib.NewWriter(writer)
-		defer writeCloser.Close()
-		writer = writeCloser
+	if header.Sign != octreeSignature {
+		return errInvalidSignature
 	}
 
-	for i := uint64(0); i < header.NumNodes; i++ {
-		if err := DecodeNode(reader, inputFormat, &color, children[:]); err != nil {
+	if header.Version > binaryVersion {
+		return errUnsupportedVersion
+	}
+
+	for _, field := range header.versionFields() {
+		if err := binary.Read(reader, header.ByteOrder(), field); err != nil {
 			return err
 		}
+	}
 
-		if err := EncodeNode(writer, format, color, children[:]); err != nil {
+	// Trees written to a stream keep the node counts last in the file. They
+	// are needed to check the far table.
+	if header.Flags&trailerMask == trailerMask {
+		if err := decodeTrailer(reader, header); err != nil {
 			return err
 		}
 	}
 
-	return nil
-}
+	if header.Format.Relative() == true {
+		if err := decodeFarTable(reader, header); err != nil {
+			return err
+		}
+	}
 
-func DecodeHeader(reader io.Reader, header *OctreeHeader) error {
-	return binary.Read(reader, binary.LittleEndian, header)
+	if header.Version >= 4 {
+		return decodeMetadataSection(reader, header)
+	}
+	return nil
 }
 
 func EncodeHeader(writer io.Writer, header OctreeHeader) error {
-	return binary.Write(writer, binary.LittleEndian, header)
+	if header.Version > binaryVersion {
+		return errUnsupportedVersion
+	}
+
+	order := header.ByteOrder()
+	for _, field := range append(header.baseFields(), header.versionFields()...) {
+		if err := binary.Write(writer, order, field); err != nil {
+			return err
+		}
+	}
+
+	if header.Format.Relative() == true {
+		if err := encodeFarTable(writer, &header); err != nil {
+			return err
+		}
+	}
+	return encodeMetadataSection(writer, &header)
 }
 
-func DecodeNode(reader io.Reader, format OctreeFormat, color *Color, children []uint32) error {
+func DecodeNode(reader io.Reader, header *OctreeHeader, index uint64, color *Color, attr *Attributes, children []uint64) error {
+	var (
+		count uint64
+		words [8]uint32
+	)
+
+	format := header.Format
+	order := header.ByteOrder()
+
+	if format.Relative() == true {
+		format = relativeFormats[format].base
+	}
+
 	readR8G8B8A8 := func() error {
 		var col [4]byte
-		if err := binary.Read(reader, binary.LittleEndian, &col); err != nil {
+		if err := binary.Read(reader, order, &col); err != nil {
 			return err
 		}
 
@@ -166,12 +452,23 @@ func DecodeNode(reader io.Reader, format OctreeFormat, color *Color, children []
 
 	readChild16 := func() error {
 		var ch [8]uint16
-		if err := binary.Read(reader, binary.LittleEndian, &ch); err != nil {
+		if err := binary.Read(reader, order, &ch); err != nil {
 			return err
 		}
 
 		for i := 0; i < 8; i++ {
-			children[i] = uint32(ch[i])
+			children[i] = uint64(ch[i])
+		}
+		return nil
+	}
+
+	readChild32 := func() error {
+		if err := binary.Read(reader, order, &words); err != nil {
+			return err
+		}
+
+		for i := 0; i < 8; i++ {
+			children[i] = uint64(words[i])
 		}
 		return nil
 	}
@@ -181,7 +478,15 @@ func DecodeNode(reader io.Reader, format OctreeFormat, color *Color, children []
 			return err
 		}
 
-		if err := binary.Read(reader, binary.LittleEndian, children); err != nil {
+		if err := readChild32(); err != nil {
+			return err
+		}
+	} else if format == MipR8G8B8A8UnpackUI64 {
+		if err := readR8G8B8A8(); err != nil {
+			return err
+		}
+
+		if err := binary.Read(reader, order, children); err != nil {
 			return err
 		}
 	} else if format == MipR8G8B8A8UnpackUI16 {
@@ -194,7 +499,7 @@ func DecodeNode(reader io.Reader, format OctreeFormat, color *Color, children []
 		}
 	} else if format == MipR4G4B4A4UnpackUI16 {
 		var col uint16
-		if err := binary.Read(reader, binary.LittleEndian, &col); err != nil {
+		if err := binary.Read(reader, order, &col); err != nil {
 			return err
 		}
 
@@ -208,7 +513,7 @@ func DecodeNode(reader io.Reader, format OctreeFormat, color *Color, children []
 		}
 	} else if format == MipR5G6B5UnpackUI16 {
 		var col uint16
-		if err := binary.Read(reader, binary.LittleEndian, &col); err != nil {
+		if err := binary.Read(reader, order, &col); err != nil {
 			return err
 		}
 
@@ -220,9 +525,9 @@ func DecodeNode(reader io.Reader, format OctreeFormat, color *Color, children []
 		if err := readChild16(); err != nil {
 			return err
 		}
-	} else if format == mipR64G64B64A64S64UnpackUI32 {
+	} else if format == mipR64G64B64A64S64UnpackUI64 {
 		var col [5]uint64
-		if err := binary.Read(reader, binary.LittleEndian, &col); err != nil {
+		if err := binary.Read(reader, order, &col); err != nil {
 			return err
 		}
 
@@ -230,34 +535,35 @@ func DecodeNode(reader io.Reader, format OctreeFormat, color *Color, children []
 		color.G = float32((col[1] / col[4])) / 255
 		color.B = float32((col[2] / col[4])) / 255
 		color.A = float32((col[3] / col[4])) / 255
+		count = col[4]
 
-		if err := binary.Read(reader, binary.LittleEndian, children); err != nil {
+		if err := binary.Read(reader, order, children); err != nil {
 			return err
 		}
 	} else if format == MipR8G8B8A8PackUI28 {
-		if err := binary.Read(reader, binary.LittleEndian, children); err != nil {
+		if err := binary.Read(reader, order, &words); err != nil {
 			return err
 		}
 
 		var cbits byte
-		for i, component := range children {
+		for i, component := range words {
 			if i%2 == 0 {
 				cbits = byte(component >> 24)
 			} else {
 				cbits |= byte(component >> 28)
 				color.setComponent(i/2, float32(cbits)/255)
 			}
-			children[i] = component & 0xfffffff
+			children[i] = uint64(component & 0xfffffff)
 		}
 	} else if format == MipR4G4B4A4PackUI30 {
-		if err := binary.Read(reader, binary.LittleEndian, children); err != nil {
+		if err := binary.Read(reader, order, &words); err != nil {
 			return err
 		}
 
 		var cbits uint16
-		for i, component := range children {
+		for i, component := range words {
 			cbits |= uint16((component & 0xc0000000) >> (16 + byte(i*2)))
-			children[i] = component & 0x3fffffff
+			children[i] = uint64(component & 0x3fffffff)
 		}
 
 		color.R = float32((cbits&0xf000)>>12) / 15
@@ -265,14 +571,14 @@ func DecodeNode(reader io.Reader, format OctreeFormat, color *Color, children []
 		color.B = float32((cbits&0xf0)>>4) / 15
 		color.A = float32(cbits&0xf) / 15
 	} else if format == MipR5G6B5PackUI30 {
-		if err := binary.Read(reader, binary.LittleEndian, children); err != nil {
+		if err := binary.Read(reader, order, &words); err != nil {
 			return err
 		}
 
 		var cbits uint16
-		for i, component := range children {
+		for i, component := range words {
 			cbits |= uint16((component & 0xc0000000) >> (16 + byte(i*2)))
-			children[i] = component & 0x3fffffff
+			children[i] = uint64(component & 0x3fffffff)
 		}
 
 		color.R = float32((cbits&0xf800)>>11) / 31
@@ -280,39 +586,105 @@ func DecodeNode(reader io.Reader, format OctreeFormat, color *Color, children []
 		color.B = float32(cbits&0x1f) / 31
 		color.A = 1
 	} else if format == MipR3G3B2PackUI31 {
-		if err := binary.Read(reader, binary.LittleEndian, children); err != nil {
+		if err := binary.Read(reader, order, &words); err != nil {
 			return err
 		}
 
 		var cbits byte
-		for i, component := range children {
+		for i, component := range words {
 			cbits |= byte((component & 0x80000000) >> (24 + byte(i)))
-			children[i] = component & 0x7fffffff
+			children[i] = uint64(component & 0x7fffffff)
 		}
 
 		color.R = float32((cbits&0xe0)>>5) / 7
 		color.G = float32((cbits&0x1c)>>2) / 7
 		color.B = float32(cbits&0x3) / 3
 		color.A = 1
+	} else if format == MipP8PackUI31 {
+		if err := binary.Read(reader, order, &words); err != nil {
+			return err
+		}
+
+		var index byte
+		for i, component := range words {
+			index |= byte((component & 0x80000000) >> (24 + byte(i)))
+			children[i] = uint64(component & 0x7fffffff)
+		}
+		*color = header.Palette.Color(index)
+	} else if format == MipR8G8B8MaskUI32 {
+		var (
+			col   [4]byte
+			first uint32
+		)
+
+		if err := binary.Read(reader, order, &col); err != nil {
+			return err
+		}
+
+		if err := binary.Read(reader, order, &first); err != nil {
+			return err
+		}
+
+		color.R = float32(col[0]) / 255
+		color.G = float32(col[1]) / 255
+		color.B = float32(col[2]) / 255
+		color.A = 1
+
+		for i := range children {
+			if col[3]&(1<<byte(i)) != 0 {
+				children[i] = uint64(first)
+				first++
+			} else {
+				children[i] = 0
+			}
+		}
 	} else {
 		return errUnsupportedFormat
 	}
-	return nil
+
+	if header.Format.Relative() == true {
+		if err := header.decodeRelative(index, children); err != nil {
+			return err
+		}
+	}
+	return decodeAttributes(reader, header, attr, count)
 }
 
-func EncodeNode(writer io.Writer, format OctreeFormat, color Color, children []uint32) error {
+func EncodeNode(writer io.Writer, header *OctreeHeader, index uint64, color Color, attr *Attributes, children []uint64) error {
+	format := header.Format
+	order := header.ByteOrder()
+
+	if format.Relative() == true {
+		var relative [8]uint64
+		if err := header.encodeRelative(index, children, relative[:]); err != nil {
+			return err
+		}
+		format = relativeFormats[format].base
+		children = relative[:]
+	}
+
 	if format == MipR8G8B8A8UnpackUI32 {
-		if err := color.writeColor(writer, format); err != nil {
+		if err := color.writeColor(writer, order, format); err != nil {
 			return err
 		}
 
-		for _, child := range children {
+		var ch [8]uint32
+		for i, child := range children {
 			if child > math.MaxUint32 {
 				return errOctreeOverflow
 			}
+			ch[i] = uint32(child)
+		}
+
+		if err := binary.Write(writer, order, ch); err != nil {
+			return err
+		}
+	} else if format == MipR8G8B8A8UnpackUI64 {
+		if err := color.writeColor(writer, order, format); err != nil {
+			return err
 		}
 
-		if err := binary.Write(writer, binary.LittleEndian, children); err != nil {
+		if err := binary.Write(writer, order, children); err != nil {
 			return err
 		}
 	} else if format == MipR8G8B8A8PackUI28 {
@@ -331,8 +703,58 @@ func EncodeNode(writer io.Writer, format OctreeFormat, color Color, children []u
 				colorNib = uint32(colors[i/2]&0xf) << 28
 			}
 
-			component = colorNib | child
-			if err := binary.Write(writer, binary.LittleEndian, component); err != nil {
+			component = colorNib | uint32(child)
+			if err := binary.Write(writer, order, component); err != nil {
+				return err
+			}
+		}
+	} else if format == MipR8G8B8MaskUI32 {
+		var (
+			mask  byte
+			first uint64
+			next  uint64
+		)
+
+		for i, child := range children {
+			if child == 0 {
+				continue
+			}
+
+			if child > math.MaxUint32 {
+				return errOctreeOverflow
+			}
+
+			if mask == 0 {
+				first = child
+			} else if child != next {
+				return errNotContiguous
+			}
+
+			mask |= 1 << byte(i)
+			next = child + 1
+		}
+
+		colors := color.bytes()
+		colors[3] = mask
+
+		if err := binary.Write(writer, order, colors); err != nil {
+			return err
+		}
+
+		if err := binary.Write(writer, order, uint32(first)); err != nil {
+			return err
+		}
+	} else if format == MipP8PackUI31 {
+		var component uint32
+		index := header.Palette.Index(color)
+
+		for i, child := range children {
+			if child > maxUint31 {
+				return errOctreeOverflow
+			}
+
+			component = ((uint32(index) << byte(24+i)) & 0x80000000) | uint32(child)
+			if err := binary.Write(writer, order, component); err != nil {
 				return err
 			}
 		}
@@ -351,8 +773,8 @@ func EncodeNode(writer io.Writer, format OctreeFormat, color Color, children []u
 				return errOctreeOverflow
 			}
 
-			component = ((uint32(packedColor) << byte(24+i)) & 0x80000000) | child
-			if err := binary.Write(writer, binary.LittleEndian, component); err != nil {
+			component = ((uint32(packedColor) << byte(24+i)) & 0x80000000) | uint32(child)
+			if err := binary.Write(writer, order, component); err != nil {
 				return err
 			}
 		}
@@ -371,8 +793,8 @@ func EncodeNode(writer io.Writer, format OctreeFormat, color Color, children []u
 				return errOctreeOverflow
 			}
 
-			component = ((uint32(packedColor) << byte(16+i*2)) & 0xc0000000) | child
-			if err := binary.Write(writer, binary.LittleEndian, component); err != nil {
+			component = ((uint32(packedColor) << byte(16+i*2)) & 0xc0000000) | uint32(child)
+			if err := binary.Write(writer, order, component); err != nil {
 				return err
 			}
 		}
@@ -392,13 +814,13 @@ func EncodeNode(writer io.Writer, format OctreeFormat, color Color, children []u
 				return errOctreeOverflow
 			}
 
-			component = ((uint32(packedColor) << byte(16+i*2)) & 0xc0000000) | child
-			if err := binary.Write(writer, binary.LittleEndian, component); err != nil {
+			component = ((uint32(packedColor) << byte(16+i*2)) & 0xc0000000) | uint32(child)
+			if err := binary.Write(writer, order, component); err != nil {
 				return err
 			}
 		}
 	} else {
-		if err := color.writeColor(writer, format); err != nil {
+		if err := color.writeColor(writer, order, format); err != nil {
 			return err
 		}
 
@@ -410,9 +832,9 @@ func EncodeNode(writer io.Writer, format OctreeFormat, color Color, children []u
 			ch[i] = uint16(child)
 		}
 
-		if err := binary.Write(writer, binary.LittleEndian, ch); err != nil {
+		if err := binary.Write(writer, order, ch); err != nil {
 			return err
 		}
 	}
-	return nil
+	return encodeAttributes(writer, header, attr)
 }
@@ -19,27 +19,38 @@ package pack
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
+	"math"
+	"reflect"
 	"testing"
 )
 
 func testDecode(format OctreeFormat, colorDiff float32) {
+	testDecodeOrder(format, binary.LittleEndian, colorDiff)
+	testDecodeOrder(format, binary.BigEndian, colorDiff)
+}
+
+func testDecodeOrder(format OctreeFormat, order binary.ByteOrder, colorDiff float32) {
 	var (
 		colorIn           Color
-		childIn, childOut [8]uint32
+		childIn, childOut [8]uint64
 		buffer            bytes.Buffer
 	)
 
+	header := OctreeHeader{Format: format}
+	header.SetByteOrder(order)
+
 	colorOut := Color{0.5, 0.3, 0.7, 1.0}
 	for i := range childOut {
-		childOut[i] = uint32(100*i - 10*i)
+		childOut[i] = uint64(100*i - 10*i)
 	}
 
-	if err := EncodeNode(&buffer, format, colorOut, childOut[:]); err != nil {
+	if err := EncodeNode(&buffer, &header, 0, colorOut, nil, childOut[:]); err != nil {
 		panic(err)
 	}
 
-	if err := DecodeNode(bytes.NewReader(buffer.Bytes()), format, &colorIn, childIn[:]); err != nil {
+	if err := DecodeNode(bytes.NewReader(buffer.Bytes()), &header, 0, &colorIn, nil, childIn[:]); err != nil {
 		panic(err)
 	}
 
@@ -64,4 +75,212 @@ func TestDecodeNode(t *testing.T) {
 	testDecode(MipR4G4B4A4PackUI30, 0.1)
 	testDecode(MipR5G6B5PackUI30, 0.1)
 	testDecode(MipR3G3B2PackUI31, 0.1)
+
+	testDecode(MipR8G8B8A8UnpackUI64, 0.01)
+
+	testDecode(MipR8G8B8A8PackRelUI28, 0.01)
+	testDecode(MipR4G4B4A4PackRelUI30, 0.1)
+	testDecode(MipR5G6B5PackRelUI30, 0.1)
+	testDecode(MipR3G3B2PackRelUI31, 0.1)
+}
+
+func TestWideIndices(t *testing.T) {
+	var (
+		buffer   bytes.Buffer
+		color    Color
+		children [8]uint64
+	)
+
+	childOut := [8]uint64{1 << 32, 0, 1 << 40, math.MaxUint64}
+	header := OctreeHeader{Format: MipR8G8B8A8UnpackUI64}
+
+	if err := EncodeNode(&buffer, &header, 0, Color{}, nil, childOut[:]); err != nil {
+		panic(err)
+	}
+
+	if err := DecodeNode(&buffer, &header, 0, &color, nil, children[:]); err != nil {
+		panic(err)
+	}
+
+	if children != childOut {
+		panic(fmt.Errorf("%v != %v", children, childOut))
+	}
+
+	for _, format := range testFormats {
+		header.Format = format
+		if format != MipR8G8B8A8UnpackUI64 && format.Relative() == false && EncodeNode(&buffer, &header, 0, Color{}, nil, childOut[:]) != errOctreeOverflow {
+			panic(fmt.Errorf("format %v did not overflow", format))
+		}
+	}
+}
+
+func TestRelativeNode(t *testing.T) {
+	var (
+		buffer   bytes.Buffer
+		color    Color
+		children [8]uint64
+	)
+
+	childOut := [8]uint64{11, 0, 12, 1 << 40, 5, 10 + 1<<27}
+	header := OctreeHeader{Format: MipR8G8B8A8PackRelUI28, FarPointers: []uint64{5, 10 + 1<<27, 1 << 40}}
+
+	if err := EncodeNode(&buffer, &header, 10, Color{}, nil, childOut[:]); err != nil {
+		panic(err)
+	}
+
+	if err := DecodeNode(&buffer, &header, 10, &color, nil, children[:]); err != nil {
+		panic(err)
+	}
+
+	if children != childOut {
+		panic(fmt.Errorf("%v != %v", children, childOut))
+	}
+
+	header.FarPointers = header.FarPointers[:2]
+	if err := EncodeNode(&buffer, &header, 10, Color{}, nil, childOut[:]); err != errFarPointer {
+		panic("expected errFarPointer")
+	}
+}
+
+func TestChildMaskNode(t *testing.T) {
+	var (
+		buffer   bytes.Buffer
+		color    Color
+		children [8]uint64
+	)
+
+	header := OctreeHeader{Format: MipR8G8B8MaskUI32}
+	for _, childOut := range [][8]uint64{{}, {0, 5, 0, 6, 7, 0, 0, 8}, {1, 2, 3, 4, 5, 6, 7, 8}} {
+		buffer.Reset()
+		if err := EncodeNode(&buffer, &header, 0, Color{}, nil, childOut[:]); err != nil {
+			panic(err)
+		}
+
+		if buffer.Len() != header.NodeSize() {
+			panic("buffer.Len() != header.NodeSize()")
+		}
+
+		if err := DecodeNode(&buffer, &header, 0, &color, nil, children[:]); err != nil {
+			panic(err)
+		}
+
+		if children != childOut {
+			panic(fmt.Errorf("%v != %v", children, childOut))
+		}
+	}
+
+	for _, childOut := range [][8]uint64{{1, 3}, {2, 1}} {
+		if err := EncodeNode(&buffer, &header, 0, Color{}, nil, childOut[:]); err != errNotContiguous {
+			panic(fmt.Errorf("expected errNotContiguous for %v", childOut))
+		}
+	}
+}
+
+func TestHeaderVersions(t *testing.T) {
+	for _, version := range []byte{0, binaryVersion} {
+		var (
+			headerIn, headerOut OctreeHeader
+			buffer              bytes.Buffer
+		)
+
+		headerOut.Sign = octreeSignature
+		headerOut.Version = version
+		headerOut.Format = MipR5G6B5PackUI30
+		headerOut.NumNodes = 12
+		headerOut.NumLeafs = 7
+		headerOut.VoxelsPerAxis = 64
+		if version > 0 {
+			headerOut.SetByteOrder(binary.BigEndian)
+			headerOut.Bounds = Box{Point{1, 2, 3}, 4}
+			headerOut.Transform[0] = 1
+			headerOut.ColorThreshold = 0.5
+			headerOut.Channels = ChannelIntensity | ChannelPointCount
+			headerOut.Format = MipR5G6B5PackRelUI30
+			headerOut.FarPointers = []uint64{3, 11}
+			headerOut.Metadata = []byte(`{"units":"m"}`)
+		}
+
+		if err := EncodeHeader(&buffer, headerOut); err != nil {
+			panic(err)
+		}
+
+		if buffer.Len() != headerOut.Size() {
+			panic(fmt.Errorf("header size %v != %v", buffer.Len(), headerOut.Size()))
+		}
+
+		if err := DecodeHeader(&buffer, &headerIn); err != nil {
+			panic(err)
+		}
+
+		if reflect.DeepEqual(headerIn, headerOut) == false {
+			panic(fmt.Errorf("%v != %v", headerIn, headerOut))
+		}
+	}
+}
+
+func TestBuildBounds(t *testing.T) {
+	var header OctreeHeader
+	if err := DecodeHeader(bytes.NewReader(buildTestTree(MipR8G8B8A8UnpackUI32)), &header); err != nil {
+		panic(err)
+	}
+
+	if header.Bounds != (Box{Point{0, 0, 0}, 80}) {
+		panic(fmt.Errorf("unexpected bounds: %v", header.Bounds))
+	}
+
+	if header.Optimized() == false || header.Filtered() == false || header.ColorThreshold != 0.25 {
+		panic("build options not recorded")
+	}
+}
+
+func decodeTestTree(data []byte) (OctreeHeader, []Color, [][8]uint64) {
+	var header OctreeHeader
+	reader := bytes.NewReader(data)
+	if err := DecodeHeader(reader, &header); err != nil {
+		panic(err)
+	}
+
+	colors := make([]Color, header.NumNodes)
+	children := make([][8]uint64, header.NumNodes)
+	for i := range colors {
+		if err := DecodeNode(reader, &header, uint64(i), &colors[i], nil, children[i][:]); err != nil {
+			panic(err)
+		}
+	}
+	return header, colors, children
+}
+
+func TestTranscodeByteOrder(t *testing.T) {
+	for _, format := range testFormats {
+		data := buildTestTree(format)
+		_, colors, children := decodeTestTree(data)
+
+		var bigEndian, littleEndian bytes.Buffer
+		if err := TranscodeTree(bytes.NewReader(data), &bigEndian, format, binary.BigEndian); err != nil {
+			panic(err)
+		}
+
+		if err := TranscodeTree(bytes.NewReader(bigEndian.Bytes()), &littleEndian, format, binary.LittleEndian); err != nil {
+			panic(err)
+		}
+
+		if bytes.Equal(data, bigEndian.Bytes()) == true {
+			panic(fmt.Errorf("format %v is not byte order sensitive", format))
+		}
+
+		if bytes.Equal(data, littleEndian.Bytes()) == false {
+			panic(fmt.Errorf("format %v did not round-trip", format))
+		}
+
+		header, beColors, beChildren := decodeTestTree(bigEndian.Bytes())
+		if header.BigEndian() == false {
+			panic("big-endian flag not set")
+		}
+
+		for i := range colors {
+			if colors[i] != beColors[i] || children[i] != beChildren[i] {
+				panic(fmt.Errorf("node %v mismatch in format %v", i, format))
+			}
+		}
+	}
 }
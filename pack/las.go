@@ -0,0 +1,249 @@
+/*
+Copyright (C) 2015-2016 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package pack
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"math"
+)
+
+var lasSignature = [4]byte{'L', 'A', 'S', 'F'}
+
+// lasPointSizes is the minimum record length of each point data format.
+var lasPointSizes = [...]uint16{20, 28, 26, 34, 57, 63, 30, 36, 38, 59, 67}
+
+// lasColorScanSize is the number of bytes of point data scanned for the color depth.
+const lasColorScanSize = 1 << 20
+
+// lasHeaderBlock is the public header block shared by LAS 1.0 to 1.4.
+type lasHeaderBlock struct {
+	Signature               [4]byte
+	FileSourceID            uint16
+	GlobalEncoding          uint16
+	GUID                    [16]byte
+	VersionMajor            uint8
+	VersionMinor            uint8
+	SystemIdentifier        [32]byte
+	GeneratingSoftware      [32]byte
+	CreationDay             uint16
+	CreationYear            uint16
+	HeaderSize              uint16
+	PointDataOffset         uint32
+	NumVLRs                 uint32
+	PointFormat             uint8
+	RecordLength            uint16
+	LegacyNumPoints         uint32
+	LegacyNumPointsByReturn [5]uint32
+	Scale                   [3]float64
+	Offset                  [3]float64
+	MaxX, MinX              float64
+	MaxY, MinY              float64
+	MaxZ, MinZ              float64
+}
+
+// lasHeaderBlock14 follows the public header block in LAS 1.4 files.
+type lasHeaderBlock14 struct {
+	WaveformOffset    uint64
+	EVLROffset        uint64
+	NumEVLRs          uint32
+	NumPoints         uint64
+	NumPointsByReturn [15]uint64
+}
+
+// LASHeader holds the fields of a LAS header needed to read the points.
+type LASHeader struct {
+	VersionMajor, VersionMinor uint8
+	PointFormat                uint8
+	RecordLength               uint16
+	NumPoints                  uint64
+	Scale, Offset              [3]float64
+	Min, Max                   Point
+}
+
+// Bounds returns a cube that contains every point in the file.
+func (h *LASHeader) Bounds() Box {
+	// Box.Intersect excludes points on the faces of the box, so the cube is
+	// padded by one unit of the point coordinates.
+	pad := math.Max(math.Max(h.Scale[0], h.Scale[1]), h.Scale[2])
+	size := math.Max(math.Max(h.Max.X-h.Min.X, h.Max.Y-h.Min.Y), h.Max.Z-h.Min.Z)
+	return Box{Point{h.Min.X - pad, h.Min.Y - pad, h.Min.Z - pad}, size + 2*pad}
+}
+
+// lasColorOffset returns the offset of the RGB values in a point record, or
+// -1 if the format has no color.
+func lasColorOffset(format uint8) int {
+	switch format {
+	case 2:
+		return 20
+	case 3, 5:
+		return 28
+	case 7, 8, 10:
+		return 30
+	}
+	return -1
+}
+
+// LASReader reads the points of a LAS 1.2 to 1.4 file as samples. Point data
+// formats 0 to 10 are supported, compressed LAZ files are not. Colors default
+// to white for formats without RGB.
+type LASReader struct {
+	reader     *bufio.Reader
+	header     LASHeader
+	record     []byte
+	read       uint64
+	colorScale float32
+}
+
+// NewLASReader reads the LAS header and skips the variable length records.
+// Colors should be 16 bit, but many writers store 8 bit values. The colors of
+// the first lasColorScanSize bytes of points are scanned and read as 8 bit
+// when no value is above 255.
+func NewLASReader(reader io.Reader) (*LASReader, error) {
+	var block lasHeaderBlock
+	if err := binary.Read(reader, binary.LittleEndian, &block); err != nil {
+		return nil, err
+	}
+
+	if block.Signature != lasSignature {
+		return nil, errInvalidSignature
+	}
+
+	if block.VersionMajor != 1 || block.VersionMinor < 2 || block.VersionMinor > 4 {
+		return nil, errUnsupportedVersion
+	}
+
+	if int(block.PointFormat) >= len(lasPointSizes) || block.RecordLength < lasPointSizes[block.PointFormat] {
+		return nil, errUnsupportedFormat
+	}
+
+	header := LASHeader{
+		VersionMajor: block.VersionMajor,
+		VersionMinor: block.VersionMinor,
+		PointFormat:  block.PointFormat,
+		RecordLength: block.RecordLength,
+		NumPoints:    uint64(block.LegacyNumPoints),
+		Scale:        block.Scale,
+		Offset:       block.Offset,
+		Min:          Point{block.MinX, block.MinY, block.MinZ},
+		Max:          Point{block.MaxX, block.MaxY, block.MaxZ},
+	}
+
+	read := int64(binary.Size(block))
+	if block.VersionMinor >= 4 && int(block.HeaderSize) >= binary.Size(block)+binary.Size(lasHeaderBlock14{}) {
+		var block14 lasHeaderBlock14
+		if err := binary.Read(reader, binary.LittleEndian, &block14); err != nil {
+			return nil, err
+		}
+
+		read += int64(binary.Size(block14))
+		if block14.NumPoints != 0 {
+			header.NumPoints = block14.NumPoints
+		}
+	}
+
+	if int64(block.PointDataOffset) < read {
+		return nil, errInvalidFile
+	}
+
+	if _, err := io.CopyN(ioutil.Discard, reader, int64(block.PointDataOffset)-read); err != nil {
+		return nil, err
+	}
+
+	r := &LASReader{reader: bufio.NewReaderSize(reader, lasColorScanSize), header: header, record: make([]byte, block.RecordLength), colorScale: 65535}
+	if lasColorOffset(header.PointFormat) >= 0 {
+		if err := r.scanColors(); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func (r *LASReader) scanColors() error {
+	size := len(r.record)
+	num := uint64(lasColorScanSize / size)
+	if num > r.header.NumPoints {
+		num = r.header.NumPoints
+	}
+
+	data, err := r.reader.Peek(int(num) * size)
+	if err != nil {
+		return err
+	}
+
+	offset := lasColorOffset(r.header.PointFormat)
+	for i := 0; i < len(data); i += size {
+		rgb := data[i+offset : i+offset+6]
+		if rgb[1] != 0 || rgb[3] != 0 || rgb[5] != 0 {
+			return nil
+		}
+	}
+
+	r.colorScale = 255
+	return nil
+}
+
+// Header returns the header of the file.
+func (r *LASReader) Header() LASHeader {
+	return r.header
+}
+
+// NumPoints returns the number of points in the file.
+func (r *LASReader) NumPoints() uint64 {
+	return r.header.NumPoints
+}
+
+// Read reads the next point into s. It returns io.EOF after the last point.
+func (r *LASReader) Read(s *Sample) error {
+	if r.read >= r.header.NumPoints {
+		return io.EOF
+	}
+
+	if _, err := io.ReadFull(r.reader, r.record); err != nil {
+		return err
+	}
+	r.read++
+
+	record, header := r.record, &r.header
+	le := binary.LittleEndian
+
+	*s = Sample{
+		Pos: Point{
+			float64(int32(le.Uint32(record[0:])))*header.Scale[0] + header.Offset[0],
+			float64(int32(le.Uint32(record[4:])))*header.Scale[1] + header.Offset[1],
+			float64(int32(le.Uint32(record[8:])))*header.Scale[2] + header.Offset[2],
+		},
+		Col: Color{1, 1, 1, 1},
+	}
+	s.Attributes.Intensity = float32(le.Uint16(record[12:]))
+
+	if header.PointFormat < 6 {
+		s.Attributes.Classification = record[15] & 0x1f
+	} else {
+		s.Attributes.Classification = record[16]
+	}
+
+	if rgb := lasColorOffset(header.PointFormat); rgb >= 0 {
+		s.Col.R = float32(le.Uint16(record[rgb:])) / r.colorScale
+		s.Col.G = float32(le.Uint16(record[rgb+2:])) / r.colorScale
+		s.Col.B = float32(le.Uint16(record[rgb+4:])) / r.colorScale
+	}
+	return nil
+}
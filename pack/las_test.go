@@ -0,0 +1,218 @@
+/*
+Copyright (C) 2015-2016 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package pack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"testing"
+)
+
+type testLASPoint struct {
+	pos     [3]int32
+	ref     uint16
+	class   uint8
+	r, g, b uint16
+}
+
+var testLASPoints = []testLASPoint{
+	{[3]int32{0, 0, 0}, 100, 2, 65535, 0, 0},
+	{[3]int32{1000, 500, 250}, 200, 6, 0, 65535, 0},
+	{[3]int32{2000, 2000, 1000}, 300, 9, 0, 0, 65535},
+}
+
+func writeTestLAS(minor, format uint8, vlr int, points []testLASPoint) []byte {
+	block := lasHeaderBlock{
+		Signature:    lasSignature,
+		VersionMajor: 1,
+		VersionMinor: minor,
+		NumVLRs:      1,
+		PointFormat:  format,
+		RecordLength: lasPointSizes[format] + 2,
+		Scale:        [3]float64{0.01, 0.01, 0.01},
+		Offset:       [3]float64{100, 200, 300},
+		MaxX:         120, MinX: 100,
+		MaxY: 220, MinY: 200,
+		MaxZ: 310, MinZ: 300,
+	}
+
+	size := binary.Size(block)
+	if minor >= 4 {
+		size += binary.Size(lasHeaderBlock14{})
+	} else {
+		block.LegacyNumPoints = uint32(len(points))
+	}
+	block.HeaderSize = uint16(size)
+	block.PointDataOffset = uint32(size + vlr)
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, block)
+	if minor >= 4 {
+		binary.Write(&buf, binary.LittleEndian, lasHeaderBlock14{NumPoints: uint64(len(points))})
+	}
+	buf.Write(make([]byte, vlr))
+
+	for _, p := range points {
+		record := make([]byte, block.RecordLength)
+		binary.LittleEndian.PutUint32(record[0:], uint32(p.pos[0]))
+		binary.LittleEndian.PutUint32(record[4:], uint32(p.pos[1]))
+		binary.LittleEndian.PutUint32(record[8:], uint32(p.pos[2]))
+		binary.LittleEndian.PutUint16(record[12:], p.ref)
+
+		rgb := 28
+		if format < 6 {
+			record[15] = p.class | 0x80
+		} else {
+			record[16] = p.class
+			rgb = 30
+		}
+
+		if format != 0 {
+			binary.LittleEndian.PutUint16(record[rgb:], p.r)
+			binary.LittleEndian.PutUint16(record[rgb+2:], p.g)
+			binary.LittleEndian.PutUint16(record[rgb+4:], p.b)
+		}
+		buf.Write(record)
+	}
+	return buf.Bytes()
+}
+
+func readLASSamples(data []byte) (LASHeader, []Sample) {
+	reader, err := NewLASReader(bytes.NewReader(data))
+	if err != nil {
+		panic(err)
+	}
+
+	var samples []Sample
+	for {
+		var s Sample
+		if err := reader.Read(&s); err == io.EOF {
+			break
+		} else if err != nil {
+			panic(err)
+		}
+		samples = append(samples, s)
+	}
+	return reader.Header(), samples
+}
+
+func TestLASReader(t *testing.T) {
+	for _, version := range []struct{ minor, format uint8 }{{2, 3}, {4, 7}} {
+		header, samples := readLASSamples(writeTestLAS(version.minor, version.format, 54, testLASPoints))
+		if header.NumPoints != 3 || len(samples) != 3 {
+			panic(fmt.Errorf("read %v points from version 1.%v", len(samples), version.minor))
+		}
+
+		for i, s := range samples {
+			p := testLASPoints[i]
+			pos := Point{float64(p.pos[0])*0.01 + 100, float64(p.pos[1])*0.01 + 200, float64(p.pos[2])*0.01 + 300}
+			col := Color{float32(p.r) / 65535, float32(p.g) / 65535, float32(p.b) / 65535, 1}
+
+			if s.Pos != pos || s.Col != col || s.Attributes.Intensity != float32(p.ref) || s.Attributes.Classification != p.class {
+				panic(fmt.Errorf("point %v of format %v is %v", i, version.format, s))
+			}
+
+			if header.Bounds().Intersect(s.Pos) == false {
+				panic(fmt.Errorf("point %v is outside of the header bounds", s.Pos))
+			}
+		}
+	}
+
+	_, samples := readLASSamples(writeTestLAS(2, 0, 0, testLASPoints))
+	for _, s := range samples {
+		if s.Col != (Color{1, 1, 1, 1}) {
+			panic("points without color should be white")
+		}
+	}
+}
+
+func TestLASReaderColorDepth(t *testing.T) {
+	points := []testLASPoint{
+		{[3]int32{0, 0, 0}, 0, 0, 255, 0, 0},
+		{[3]int32{100, 0, 0}, 0, 0, 0, 128, 255},
+	}
+	expected := []Color{{1, 0, 0, 1}, {0, float32(128) / 255, 1, 1}}
+
+	for _, version := range []struct{ minor, format uint8 }{{2, 3}, {4, 7}} {
+		format := version.format
+		data := writeTestLAS(version.minor, format, 0, points)
+
+		_, samples := readLASSamples(data)
+		for i, s := range samples {
+			if s.Col != expected[i] {
+				panic(fmt.Errorf("8 bit color %v read as %v in format %v", expected[i], s.Col, format))
+			}
+		}
+
+		// The colors are scanned without seeking.
+		reader, err := NewLASReader(io.MultiReader(bytes.NewReader(data)))
+		if err != nil {
+			panic(err)
+		}
+
+		var s Sample
+		if err := reader.Read(&s); err != nil {
+			panic(err)
+		}
+
+		if s.Col != expected[0] {
+			panic(fmt.Errorf("8 bit color read as %v", s.Col))
+		}
+	}
+
+	// A single value above 255 makes the colors 16 bit.
+	points[1].b = 256
+	_, samples := readLASSamples(writeTestLAS(2, 3, 0, points))
+	if samples[0].Col != (Color{float32(255) / 65535, 0, 0, 1}) {
+		panic(fmt.Errorf("16 bit color read as %v", samples[0].Col))
+	}
+
+	// Only the start of the point data is scanned.
+	points = make([]testLASPoint, lasColorScanSize/int(lasPointSizes[3])+1)
+	points[len(points)-1].b = 256
+	_, samples = readLASSamples(writeTestLAS(2, 3, 0, points))
+	if samples[len(samples)-1].Col != (Color{0, 0, float32(256) / 255, 1}) {
+		panic(fmt.Errorf("color outside of the scan read as %v", samples[len(samples)-1].Col))
+	}
+}
+
+func TestLASReaderInvalid(t *testing.T) {
+	data := writeTestLAS(2, 3, 0, testLASPoints)
+
+	invalid := [][]byte{data[:100], append([]byte("LASG"), data[4:]...)}
+
+	version := append([]byte{}, data...)
+	version[25] = 5
+	invalid = append(invalid, version)
+
+	version = append([]byte{}, data...)
+	version[25] = 1
+	invalid = append(invalid, version)
+
+	compressed := append([]byte{}, data...)
+	compressed[104] |= 0x80
+	invalid = append(invalid, compressed)
+
+	for i, data := range invalid {
+		if _, err := NewLASReader(bytes.NewReader(data)); err == nil {
+			panic(fmt.Errorf("accepted invalid file %v", i))
+		}
+	}
+}
@@ -0,0 +1,177 @@
+/*
+Copyright (C) 2015-2016 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package pack
+
+import (
+	"io"
+	"io/ioutil"
+)
+
+// relayoutTree writes the tree in breadth-first order, which stores the
+// children of every node contiguously as required by the child-mask formats.
+func relayoutTree(tree *OctreeReader, writer io.Writer, outputHeader OctreeHeader) error {
+	var (
+		color    Color
+		attr     Attributes
+		children [8]uint64
+	)
+
+	if err := EncodeHeader(writer, outputHeader); err != nil {
+		return err
+	}
+
+	nodeWriter, err := NewNodeWriter(writer, &outputHeader)
+	if err != nil {
+		return err
+	}
+
+	numNodes := tree.NumNodes()
+	queue := make([]uint64, 1, numNodes)
+
+	for i := 0; i < len(queue); i++ {
+		if err := tree.ReadNode(queue[i], &color, &attr, children[:]); err != nil {
+			nodeWriter.Close()
+			return err
+		}
+
+		for j, child := range children {
+			if child == 0 {
+				continue
+			}
+
+			// Shared nodes would be written more than once.
+			if uint64(len(queue)) == numNodes {
+				nodeWriter.Close()
+				return errTreeLayout
+			}
+
+			children[j] = uint64(len(queue))
+			queue = append(queue, child)
+		}
+
+		if err := EncodeNode(nodeWriter, &outputHeader, uint64(i), color, &attr, children[:]); err != nil {
+			nodeWriter.Close()
+			return err
+		}
+	}
+
+	if uint64(len(queue)) != numNodes {
+		nodeWriter.Close()
+		return errTreeLayout
+	}
+
+	return nodeWriter.Close()
+}
+
+// inputCodec returns the codec of the tree in reader, which an OctreeReader
+// only knows before the tree is decompressed.
+func inputCodec(reader io.ReaderAt) (Codec, error) {
+	var header OctreeHeader
+	if err := DecodeHeader(sectionReader(reader), &header); err != nil {
+		return nil, err
+	}
+	return header.codec()
+}
+
+// subtreeNode is a node visited by writeSubtree, depth is relative to the
+// root of the input tree.
+type subtreeNode struct {
+	index  uint64
+	bounds Box
+	depth  int
+}
+
+// writeSubtree writes the nodes below root, for which keep returns true, as a
+// new tree in the format of outputHeader compressed with codec. A node whose
+// children are all dropped becomes a leaf.
+func writeSubtree(tree *OctreeReader, root subtreeNode, outputHeader OctreeHeader, codec Codec, writer io.Writer, keep func(child subtreeNode) bool) error {
+	var (
+		color    Color
+		attr     Attributes
+		children [8]uint64
+	)
+
+	fp, err := ioutil.TempFile("", "")
+	if err != nil {
+		return err
+	}
+	defer removeTempFile(fp)
+
+	format := outputHeader.Format
+	outputHeader.Format = MipR8G8B8A8UnpackUI64
+	outputHeader.Flags &^= dagMask
+	outputHeader.FarPointers = nil
+
+	w, err := NewOctreeWriter(fp, outputHeader, codec)
+	if err != nil {
+		return err
+	}
+
+	// Nodes are written breadth first, so the index of every child is known
+	// when its parent is written.
+	next := uint64(1)
+	queue := []subtreeNode{root}
+
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+
+		// Deeper than any tree can be, there must be a cycle.
+		if node.depth > 64 {
+			return errTreeLayout
+		}
+
+		if err := tree.ReadNode(node.index, &color, &attr, children[:]); err != nil {
+			return err
+		}
+
+		for i, child := range children {
+			if child == 0 {
+				continue
+			}
+
+			if child >= tree.NumNodes() {
+				return errNodeOutOfRange
+			}
+
+			sub := subtreeNode{child, childBox(node.bounds, i), node.depth + 1}
+			if keep(sub) == false {
+				children[i] = 0
+				continue
+			}
+
+			queue = append(queue, sub)
+			children[i] = next
+			next++
+		}
+
+		if err := w.WriteNode(color, &attr, children[:]); err != nil {
+			return err
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	if _, err := fp.Seek(0, 0); err != nil {
+		return err
+	}
+
+	return TranscodeTree(fp, writer, format, outputHeader.ByteOrder())
+}
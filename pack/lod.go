@@ -0,0 +1,109 @@
+/*
+Copyright (C) 2015-2016 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package pack
+
+import (
+	"io"
+	"math"
+)
+
+// TruncateStatus compares a tree with its truncated copy. Sizes are in bytes,
+// the input size is zero when it could not be determined.
+type TruncateStatus struct {
+	InputNodes, OutputNodes uint64
+	InputSize, OutputSize   int64
+}
+
+// Saved returns the number of bytes saved by the truncation.
+func (s TruncateStatus) Saved() int64 {
+	if s.InputSize == 0 {
+		return 0
+	}
+	return s.InputSize - s.OutputSize
+}
+
+type countingWriter struct {
+	writer io.Writer
+	count  int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.count += int64(n)
+	return n, err
+}
+
+// TruncateTree writes the tree in reader with the levels below voxelsPerAxis
+// dropped. Nodes at the new deepest level become leafs and keep their mipped
+// color. The output has the format and codec of the input.
+func TruncateTree(reader io.ReaderAt, writer io.Writer, voxelsPerAxis int) (TruncateStatus, error) {
+	var status TruncateStatus
+
+	vpa := uint32(voxelsPerAxis)
+	if voxelsPerAxis <= 0 || (vpa&(vpa-1)) != 0 {
+		return status, errVoxelsPowerOfTwo
+	}
+
+	tree, err := NewOctreeReader(reader)
+	if err != nil {
+		return status, err
+	}
+	defer tree.Close()
+
+	header := tree.Header()
+	if vpa > header.VoxelsPerAxis {
+		return status, errVoxelsPerAxis
+	}
+
+	if tree.NumNodes() == 0 {
+		return status, errEmptyTree
+	}
+
+	codec, err := inputCodec(reader)
+	if err != nil {
+		return status, err
+	}
+
+	maxDepth := voxelDepth(vpa)
+
+	outputHeader := header
+	outputHeader.VoxelsPerAxis = vpa
+
+	counter := &countingWriter{writer: writer}
+	root := subtreeNode{0, header.Bounds, 0}
+	status.OutputNodes = 1
+
+	err = writeSubtree(tree, root, outputHeader, codec, counter, func(child subtreeNode) bool {
+		if child.depth > maxDepth {
+			return false
+		}
+		status.OutputNodes++
+		return true
+	})
+	if err != nil {
+		return status, err
+	}
+
+	if size := sectionReader(reader).Size(); size < math.MaxInt64 {
+		status.InputSize = size
+	}
+
+	status.InputNodes = tree.NumNodes()
+	status.OutputSize = counter.count
+	return status, nil
+}
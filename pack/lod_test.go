@@ -0,0 +1,78 @@
+/*
+Copyright (C) 2015-2016 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package pack
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestTruncateTree(t *testing.T) {
+	for _, format := range []OctreeFormat{MipR8G8B8A8PackUI28, MipR8G8B8MaskUI32, MipP8PackUI31} {
+		var built bytes.Buffer
+
+		cfg := testBuildConfig(format, &built)
+		cfg.Optimize = false
+		if _, err := BuildTree(&cfg); err != nil {
+			panic(err)
+		}
+
+		header, colors, _ := decodeTestTree(built.Bytes())
+
+		// Leafs and total nodes of the test tree at 1, 2, 4 and 8 voxels per axis.
+		expected := [][2]uint64{{1, 1}, {2, 3}, {5, 8}, {7, 15}}
+
+		for i, vpa := range []int{1, 2, 4, 8} {
+			var truncated bytes.Buffer
+
+			status, err := TruncateTree(bytes.NewReader(built.Bytes()), &truncated, vpa)
+			if err != nil {
+				panic(err)
+			}
+
+			report, err := Validate(bytes.NewReader(truncated.Bytes()))
+			if err != nil {
+				panic(err)
+			}
+
+			h := report.Header
+			if report.Valid() == false || h.Format != format || h.VoxelsPerAxis != uint32(vpa) || h.Bounds != header.Bounds {
+				panic(fmt.Errorf("invalid truncated tree in format %v: %+v", format, report))
+			}
+
+			if h.NumLeafs != expected[i][0] || h.NumNodes != expected[i][1] || status.OutputNodes != h.NumNodes {
+				panic(fmt.Errorf("%v voxels per axis: %v leafs, %v nodes", vpa, h.NumLeafs, h.NumNodes))
+			}
+
+			if status.InputSize != int64(built.Len()) || status.OutputSize != int64(truncated.Len()) || status.InputNodes != header.NumNodes {
+				panic(fmt.Errorf("unexpected status: %+v", status))
+			}
+
+			_, c, _ := decodeTestTree(truncated.Bytes())
+			if c[0] != colors[0] {
+				panic("root color changed")
+			}
+		}
+
+		var truncated bytes.Buffer
+		if _, err := TruncateTree(bytes.NewReader(built.Bytes()), &truncated, 16); err != errVoxelsPerAxis {
+			panic("expected errVoxelsPerAxis")
+		}
+	}
+}
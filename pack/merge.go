@@ -0,0 +1,191 @@
+/*
+Copyright (C) 2015-2016 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package pack
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// MergeConfig describes the trees combined by MergeTrees.
+type MergeConfig struct {
+	Trees []io.ReaderAt
+
+	// Bounds of each tree, a zero sized box uses the bounds in the header.
+	Bounds []Box
+
+	Writer         io.Writer
+	Format         OctreeFormat
+	Optimize       bool
+	ColorFilter    bool
+	ColorThreshold float32
+	ByteOrder      binary.ByteOrder
+
+	// VoxelsPerAxis of the merged tree, zero keeps the voxel size of the
+	// most detailed input.
+	VoxelsPerAxis int
+}
+
+// MergeTrees builds a single tree over the joined bounds of several trees.
+// All trees must have the same transform.
+// Every leaf is inserted as a sample weighted by its point count, so voxels
+// covered by more than one tree are averaged by the number of points behind
+// them. Trees without the point count channel weigh every leaf as one point.
+func MergeTrees(cfg *MergeConfig) (BuildStatus, error) {
+	var status BuildStatus
+
+	if len(cfg.Trees) == 0 {
+		return status, errEmptyTree
+	}
+
+	trees := make([]*OctreeReader, len(cfg.Trees))
+	bounds := make([]Box, len(cfg.Trees))
+	defer func() {
+		for _, tree := range trees {
+			if tree != nil {
+				tree.Close()
+			}
+		}
+	}()
+
+	var (
+		channels  Channels
+		voxelSize = math.MaxFloat64
+		joined    Box
+		max       Point
+	)
+
+	for i, reader := range cfg.Trees {
+		tree, err := NewOctreeReader(reader)
+		if err != nil {
+			return status, err
+		}
+		trees[i] = tree
+
+		header := tree.Header()
+		bounds[i] = header.Bounds
+		if i < len(cfg.Bounds) && cfg.Bounds[i].Size > 0 {
+			bounds[i] = cfg.Bounds[i]
+		}
+
+		if bounds[i].Size <= 0 || header.VoxelsPerAxis == 0 {
+			return status, errMissingBounds
+		}
+
+		b := bounds[i]
+		if i == 0 {
+			joined.Pos = b.Pos
+			max = b.Pos
+		}
+
+		joined.Pos = Point{math.Min(joined.Pos.X, b.Pos.X), math.Min(joined.Pos.Y, b.Pos.Y), math.Min(joined.Pos.Z, b.Pos.Z)}
+		max = Point{math.Max(max.X, b.Pos.X+b.Size), math.Max(max.Y, b.Pos.Y+b.Size), math.Max(max.Z, b.Pos.Z+b.Size)}
+
+		if header.Transform != trees[0].Header().Transform {
+			return status, errTransform
+		}
+
+		voxelSize = math.Min(voxelSize, b.Size/float64(header.VoxelsPerAxis))
+		channels |= header.Channels
+	}
+
+	joined.Size = math.Max(max.X-joined.Pos.X, math.Max(max.Y-joined.Pos.Y, max.Z-joined.Pos.Z))
+
+	vpa := cfg.VoxelsPerAxis
+	if vpa == 0 {
+		for vpa = 1; joined.Size/float64(vpa) > voxelSize; vpa *= 2 {
+		}
+	}
+
+	// Samples are moved to the center of their output voxel, so none of them
+	// end up on the boundary between two voxels.
+	size := joined.Size / float64(vpa)
+	snap := func(v, pos float64) float64 {
+		n := math.Floor((v - pos) / size)
+		return pos + (math.Max(0, math.Min(n, float64(vpa-1)))+0.5)*size
+	}
+
+	worker := func(samples chan<- Sample) error {
+		for i, tree := range trees {
+			err := treeSamples(tree, bounds[i], func(s Sample) {
+				s.Pos = Point{snap(s.Pos.X, joined.Pos.X), snap(s.Pos.Y, joined.Pos.Y), snap(s.Pos.Z, joined.Pos.Z)}
+				samples <- s
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	buildCfg := BuildConfig{
+		Worker:         worker,
+		Writer:         cfg.Writer,
+		Bounds:         joined,
+		VoxelsPerAxis:  vpa,
+		Format:         cfg.Format,
+		Optimize:       cfg.Optimize,
+		ColorFilter:    cfg.ColorFilter,
+		ColorThreshold: cfg.ColorThreshold,
+		Transform:      trees[0].Header().Transform,
+		ByteOrder:      cfg.ByteOrder,
+		Channels:       channels,
+	}
+
+	return BuildTree(&buildCfg)
+}
+
+// treeSamples calls fn with a sample at the center of every leaf of tree. A
+// leaf above the deepest level, as left by OptimizeTree when it merges full
+// nodes, has its point count split exactly between the voxels it covers, the
+// first octants get the remainder and voxels left without points are skipped.
+// Leafs without a point count are passed on as a single sample.
+func treeSamples(tree *OctreeReader, bounds Box, fn func(Sample)) error {
+	maxDepth := voxelDepth(tree.Header().VoxelsPerAxis)
+
+	var split func(box Box, depth int, color Color, attr Attributes)
+	split = func(box Box, depth int, color Color, attr Attributes) {
+		if depth >= maxDepth || attr.PointCount == 0 {
+			half := box.Size * 0.5
+			fn(Sample{Pos: Point{box.Pos.X + half, box.Pos.Y + half, box.Pos.Z + half}, Col: color, Attributes: attr})
+			return
+		}
+
+		count := attr.PointCount
+		for i := range childPositions {
+			attr.PointCount = count / 8
+			if uint32(i) < count%8 {
+				attr.PointCount++
+			}
+
+			if attr.PointCount > 0 {
+				split(childBox(box, i), depth+1, color, attr)
+			}
+		}
+	}
+
+	return walkTree(tree, bounds, func(index uint64, box Box, depth int, color Color, attr *Attributes, children []uint64) (bool, error) {
+		if noChildren(children) == false {
+			return true, nil
+		}
+
+		split(box, depth, color, *attr)
+		return false, nil
+	})
+}
@@ -0,0 +1,218 @@
+/*
+Copyright (C) 2015-2016 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package pack
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestMergeTrees(t *testing.T) {
+	var built bytes.Buffer
+	cfg := testBuildConfig(MipR8G8B8A8UnpackUI32, &built)
+	cfg.Optimize = false
+	cfg.Channels = ChannelPointCount
+	if _, err := BuildTree(&cfg); err != nil {
+		panic(err)
+	}
+
+	data := built.Bytes()
+	header, root := testRootAttributes(data)
+
+	for _, shift := range []float64{0, 80} {
+		var merged bytes.Buffer
+
+		mergeCfg := MergeConfig{
+			Trees:  []io.ReaderAt{bytes.NewReader(data), bytes.NewReader(data)},
+			Bounds: []Box{Box{}, Box{Point{shift, 0, 0}, 80}},
+			Writer: &merged,
+			Format: MipR8G8B8A8UnpackUI32,
+		}
+
+		if _, err := MergeTrees(&mergeCfg); err != nil {
+			panic(err)
+		}
+
+		h, attr := testRootAttributes(merged.Bytes())
+		if attr.PointCount != root.PointCount*2 {
+			panic(fmt.Errorf("merged point count %v, expected %v", attr.PointCount, root.PointCount*2))
+		}
+
+		numLeafs, size := header.NumLeafs, 80.0
+		if shift > 0 {
+			numLeafs, size = numLeafs*2, 160
+		}
+
+		if h.NumLeafs != numLeafs || h.Bounds != (Box{Point{0, 0, 0}, size}) || float64(h.VoxelsPerAxis) != size/10 {
+			panic(fmt.Errorf("unexpected merged tree: %v leafs, %v bounds, %v voxels per axis", h.NumLeafs, h.Bounds, h.VoxelsPerAxis))
+		}
+
+		report, err := Validate(bytes.NewReader(merged.Bytes()))
+		if err != nil {
+			panic(err)
+		}
+		if report.Valid() == false {
+			panic(fmt.Errorf("invalid merged tree: %+v", report))
+		}
+	}
+}
+
+func TestMergeWeights(t *testing.T) {
+	var a, b, merged bytes.Buffer
+
+	white := Color{1, 1, 1, 1}
+	black := Color{0, 0, 0, 1}
+
+	for _, tree := range []struct {
+		buffer *bytes.Buffer
+		color  Color
+		count  int
+	}{{&a, white, 1}, {&b, black, 3}} {
+		w, err := NewOctreeWriter(tree.buffer, OctreeHeader{Format: MipR8G8B8A8UnpackUI32, VoxelsPerAxis: 1, Bounds: Box{Size: 1}, Channels: ChannelPointCount}, nil)
+		if err != nil {
+			panic(err)
+		}
+		if err := w.WriteNode(tree.color, &Attributes{PointCount: uint32(tree.count)}, make([]uint64, 8)); err != nil {
+			panic(err)
+		}
+		if err := w.Close(); err != nil {
+			panic(err)
+		}
+	}
+
+	cfg := MergeConfig{Trees: []io.ReaderAt{bytes.NewReader(a.Bytes()), bytes.NewReader(b.Bytes())}, Writer: &merged, Format: MipR8G8B8A8UnpackUI32}
+	if _, err := MergeTrees(&cfg); err != nil {
+		panic(err)
+	}
+
+	_, colors, _ := decodeTestTree(merged.Bytes())
+	if len(colors) != 1 || colors[0].R < 0.24 || colors[0].R > 0.26 {
+		panic(fmt.Errorf("unexpected merged colors: %v", colors))
+	}
+}
+
+func TestMergeSplitCount(t *testing.T) {
+	var a, b bytes.Buffer
+
+	// A single leaf covering all 4x4x4 voxels, as left by OptimizeTree.
+	header := OctreeHeader{Format: MipR8G8B8A8UnpackUI32, VoxelsPerAxis: 4, Bounds: Box{Size: 4}, Channels: ChannelPointCount}
+	w, err := NewOctreeWriter(&a, header, nil)
+	if err != nil {
+		panic(err)
+	}
+	if err := w.WriteNode(Color{1, 1, 1, 1}, &Attributes{PointCount: 67}, make([]uint64, 8)); err != nil {
+		panic(err)
+	}
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+
+	tree, err := NewOctreeReader(bytes.NewReader(a.Bytes()))
+	if err != nil {
+		panic(err)
+	}
+	defer tree.Close()
+
+	var (
+		numSamples int
+		sum        uint32
+	)
+
+	positions := make(map[Point]bool)
+	err = treeSamples(tree, header.Bounds, func(s Sample) {
+		numSamples++
+		sum += s.Attributes.PointCount
+		positions[s.Pos] = true
+
+		if s.Attributes.PointCount != 1 && s.Attributes.PointCount != 2 {
+			panic(fmt.Errorf("uneven point count %v", s.Attributes.PointCount))
+		}
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	if numSamples != 64 || len(positions) != 64 || sum != 67 {
+		panic(fmt.Errorf("%v samples in %v voxels with %v points, expected 64 and 67", numSamples, len(positions), sum))
+	}
+
+	header.Transform[0] = 1
+	w, err = NewOctreeWriter(&b, header, nil)
+	if err != nil {
+		panic(err)
+	}
+	if err := w.WriteNode(Color{1, 1, 1, 1}, &Attributes{PointCount: 1}, make([]uint64, 8)); err != nil {
+		panic(err)
+	}
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+
+	var merged bytes.Buffer
+	cfg := MergeConfig{Trees: []io.ReaderAt{bytes.NewReader(a.Bytes()), bytes.NewReader(b.Bytes())}, Writer: &merged, Format: MipR8G8B8A8UnpackUI32}
+	if _, err := MergeTrees(&cfg); err != errTransform {
+		panic(fmt.Errorf("merged trees with different transforms: %v", err))
+	}
+}
+
+func TestMergeSmallCounts(t *testing.T) {
+	for _, channels := range []Channels{ChannelPointCount, 0} {
+		var tree, merged bytes.Buffer
+
+		// Two leafs above the deepest level with fewer points than voxels.
+		header := OctreeHeader{Format: MipR8G8B8A8UnpackUI32, VoxelsPerAxis: 4, Bounds: Box{Size: 4}, Channels: channels}
+		w, err := NewOctreeWriter(&tree, header, nil)
+		if err != nil {
+			panic(err)
+		}
+
+		nodes := []struct {
+			children [8]uint64
+			count    uint32
+		}{{[8]uint64{1, 0, 0, 0, 0, 0, 0, 2}, 4}, {count: 1}, {count: 3}}
+
+		for _, node := range nodes {
+			if err := w.WriteNode(Color{1, 1, 1, 1}, &Attributes{PointCount: node.count}, node.children[:]); err != nil {
+				panic(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			panic(err)
+		}
+
+		cfg := MergeConfig{Trees: []io.ReaderAt{bytes.NewReader(tree.Bytes())}, Writer: &merged, Format: MipR8G8B8A8UnpackUI32}
+		status, err := MergeTrees(&cfg)
+		if err != nil {
+			panic(err)
+		}
+
+		h, attr := testRootAttributes(merged.Bytes())
+		if channels == 0 {
+			if h.Channels != 0 || h.NumLeafs != 2 {
+				panic(fmt.Errorf("leafs without counts merged into %v leafs", h.NumLeafs))
+			}
+			continue
+		}
+
+		if attr.PointCount != 4 || h.NumLeafs != 4 {
+			panic(fmt.Errorf("merged %v points in %v leafs, expected 4 in 4: %v", attr.PointCount, h.NumLeafs, status))
+		}
+	}
+}
@@ -0,0 +1,315 @@
+/*
+Copyright (C) 2015-2016 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package pack
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// MeshFormat is a mesh file format written by ExportMesh.
+type MeshFormat int
+
+const (
+	MeshOBJ MeshFormat = iota
+	MeshPLY
+)
+
+type MeshConfig struct {
+	Format MeshFormat
+
+	// Depth of the voxels that make up the surface, -1 uses the deepest
+	// level of the tree.
+	Depth int
+
+	// Smoothing iterations applied to the surface, zero keeps the voxel faces.
+	Smooth int
+}
+
+type MeshStatus struct {
+	NumVertices, NumFaces int
+}
+
+type meshVertex struct {
+	pos   [3]float64
+	color [4]float64
+}
+
+type meshBuilder struct {
+	cells    map[gridCell]Color
+	order    []gridCell
+	vertices []meshVertex
+	index    map[gridCell]int
+	faces    [][3]int
+	edges    map[[2]int]struct{}
+}
+
+func (m *meshBuilder) vertex(corner gridCell, color Color) int {
+	i, ok := m.index[corner]
+	if ok == false {
+		i = len(m.vertices)
+		m.index[corner] = i
+		m.vertices = append(m.vertices, meshVertex{pos: [3]float64{float64(corner[0]), float64(corner[1]), float64(corner[2])}})
+	}
+
+	v := &m.vertices[i]
+	v.color[0] += float64(color.R)
+	v.color[1] += float64(color.G)
+	v.color[2] += float64(color.B)
+	v.color[3]++
+	return i
+}
+
+func (m *meshBuilder) edge(a, b int) {
+	if a > b {
+		a, b = b, a
+	}
+	m.edges[[2]int{a, b}] = struct{}{}
+}
+
+// addFaces adds a quad for every side of every cell that faces an empty cell.
+// The corners are ordered counter-clockwise seen from outside.
+func (m *meshBuilder) addFaces() {
+	for _, cell := range m.order {
+		color := m.cells[cell]
+		for axis := 0; axis < 3; axis++ {
+			u, v := (axis+1)%3, (axis+2)%3
+			for _, side := range []int64{-1, 1} {
+				neighbor := cell
+				neighbor[axis] += side
+				if _, ok := m.cells[neighbor]; ok == true {
+					continue
+				}
+
+				var corners [4]gridCell
+				for i := range corners {
+					corners[i] = cell
+				}
+				if side > 0 {
+					for i := range corners {
+						corners[i][axis]++
+					}
+					corners[1][u]++
+					corners[2][u]++
+					corners[2][v]++
+					corners[3][v]++
+				} else {
+					corners[1][v]++
+					corners[2][u]++
+					corners[2][v]++
+					corners[3][u]++
+				}
+
+				var quad [4]int
+				for i, corner := range corners {
+					quad[i] = m.vertex(corner, color)
+				}
+
+				m.faces = append(m.faces, [3]int{quad[0], quad[1], quad[2]}, [3]int{quad[0], quad[2], quad[3]})
+				for i := range quad {
+					m.edge(quad[i], quad[(i+1)%4])
+				}
+			}
+		}
+	}
+}
+
+// smooth moves the vertices towards their neighbors along the quad edges,
+// alternating shrinking and inflating steps to keep the volume.
+func (m *meshBuilder) smooth(iterations int) {
+	neighbors := make([][]int, len(m.vertices))
+	for edge := range m.edges {
+		neighbors[edge[0]] = append(neighbors[edge[0]], edge[1])
+		neighbors[edge[1]] = append(neighbors[edge[1]], edge[0])
+	}
+
+	pos := make([][3]float64, len(m.vertices))
+	for n := 0; n < iterations*2; n++ {
+		factor := 0.5
+		if n%2 == 1 {
+			factor = -0.53
+		}
+
+		for i, adjacent := range neighbors {
+			var avg [3]float64
+			for _, j := range adjacent {
+				for k := range avg {
+					avg[k] += m.vertices[j].pos[k]
+				}
+			}
+
+			for k := range avg {
+				p := m.vertices[i].pos[k]
+				pos[i][k] = p + factor*(avg[k]/float64(len(adjacent))-p)
+			}
+		}
+
+		for i := range pos {
+			m.vertices[i].pos = pos[i]
+		}
+	}
+}
+
+// ExportMesh writes the surface of the voxels at the configured depth as a
+// closed triangle mesh. Only faces between occupied and empty voxels are
+// written, and vertices are shared by all faces that meet in them. Vertex
+// colors are the average color of those faces.
+//
+// Like ExportPoints the vertices are written in the coordinates of the source
+// cloud, the inverse of the header transform is applied to them.
+func ExportMesh(reader io.ReaderAt, writer io.Writer, cfg *MeshConfig) (MeshStatus, error) {
+	var status MeshStatus
+
+	tree, err := NewOctreeReader(reader)
+	if err != nil {
+		return status, err
+	}
+	defer tree.Close()
+
+	header := tree.Header()
+	if header.Bounds.Size <= 0 || header.VoxelsPerAxis == 0 {
+		return status, errMissingBounds
+	}
+
+	inverse, err := inverseTransform(header.Transform)
+	if err != nil {
+		return status, err
+	}
+
+	maxDepth := voxelDepth(header.VoxelsPerAxis)
+
+	depth := cfg.Depth
+	if depth < 0 || depth > maxDepth {
+		depth = maxDepth
+	}
+
+	m := meshBuilder{
+		cells: make(map[gridCell]Color),
+		index: make(map[gridCell]int),
+		edges: make(map[[2]int]struct{}),
+	}
+
+	err = walkCells(tree, depth, func(cell gridCell, color Color) error {
+		if _, ok := m.cells[cell]; ok == false {
+			m.order = append(m.order, cell)
+		}
+		m.cells[cell] = color
+		return nil
+	})
+	if err != nil {
+		return status, err
+	}
+
+	m.addFaces()
+	if cfg.Smooth > 0 {
+		m.smooth(cfg.Smooth)
+	}
+
+	cellSize := header.Bounds.Size / float64(int64(1)<<uint(depth))
+	origin := [3]float64{header.Bounds.Pos.X, header.Bounds.Pos.Y, header.Bounds.Pos.Z}
+	for i := range m.vertices {
+		v := &m.vertices[i]
+		p := Point{v.pos[0]*cellSize + origin[0], v.pos[1]*cellSize + origin[1], v.pos[2]*cellSize + origin[2]}
+		p = p.transform(&inverse)
+		v.pos = [3]float64{p.X, p.Y, p.Z}
+
+		for k := 0; k < 3; k++ {
+			v.color[k] /= v.color[3]
+		}
+	}
+
+	// A mirroring transform turns the faces inside out.
+	if transformDeterminant(&inverse) < 0 {
+		for i := range m.faces {
+			f := &m.faces[i]
+			f[1], f[2] = f[2], f[1]
+		}
+	}
+
+	status = MeshStatus{len(m.vertices), len(m.faces)}
+	buffer := bufio.NewWriter(writer)
+
+	switch cfg.Format {
+	case MeshOBJ:
+		err = writeOBJ(buffer, &m)
+	case MeshPLY:
+		err = writeMeshPLY(buffer, &m)
+	default:
+		return status, errUnsupportedFormat
+	}
+
+	if err != nil {
+		return status, err
+	}
+	return status, buffer.Flush()
+}
+
+func writeOBJ(writer io.Writer, m *meshBuilder) error {
+	if _, err := fmt.Fprintln(writer, "# octatron"); err != nil {
+		return err
+	}
+
+	for _, v := range m.vertices {
+		if _, err := fmt.Fprintf(writer, "v %v %v %v %.4f %.4f %.4f\n", v.pos[0], v.pos[1], v.pos[2], v.color[0], v.color[1], v.color[2]); err != nil {
+			return err
+		}
+	}
+
+	for _, f := range m.faces {
+		if _, err := fmt.Fprintf(writer, "f %v %v %v\n", f[0]+1, f[1]+1, f[2]+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeMeshPLY(writer io.Writer, m *meshBuilder) error {
+	const header = "ply\nformat binary_little_endian 1.0\ncomment octatron\n" +
+		"element vertex %v\nproperty double x\nproperty double y\nproperty double z\n" +
+		"property uchar red\nproperty uchar green\nproperty uchar blue\n" +
+		"element face %v\nproperty list uchar int vertex_indices\nend_header\n"
+
+	if _, err := fmt.Fprintf(writer, header, len(m.vertices), len(m.faces)); err != nil {
+		return err
+	}
+
+	for _, v := range m.vertices {
+		if err := binary.Write(writer, binary.LittleEndian, v.pos); err != nil {
+			return err
+		}
+
+		color := [3]byte{colorByte(float32(v.color[0])), colorByte(float32(v.color[1])), colorByte(float32(v.color[2]))}
+		if err := binary.Write(writer, binary.LittleEndian, color); err != nil {
+			return err
+		}
+	}
+
+	for _, f := range m.faces {
+		face := struct {
+			Count   byte
+			Indices [3]int32
+		}{3, [3]int32{int32(f[0]), int32(f[1]), int32(f[2])}}
+
+		if err := binary.Write(writer, binary.LittleEndian, &face); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+/*
+Copyright (C) 2015-2016 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package pack
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// testMeshEdges checks that every edge of the mesh is used once in each
+// direction, so the mesh is closed and consistently oriented.
+func testMeshEdges(data []byte) (int, int) {
+	var (
+		vertices int
+		edges    = make(map[[2]int]int)
+	)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "v ") {
+			vertices++
+		} else if strings.HasPrefix(line, "f ") {
+			var f [3]int
+			if _, err := fmt.Sscanf(line, "f %d %d %d", &f[0], &f[1], &f[2]); err != nil {
+				panic(err)
+			}
+			for i := range f {
+				edges[[2]int{f[i], f[(i+1)%3]}]++
+			}
+		}
+	}
+
+	for edge, n := range edges {
+		if n != 1 || edges[[2]int{edge[1], edge[0]}] != 1 {
+			panic(fmt.Errorf("edge %v is not shared by exactly two faces", edge))
+		}
+	}
+	return vertices, len(edges) / 3
+}
+
+func TestExportMesh(t *testing.T) {
+	var single, built bytes.Buffer
+
+	w, err := NewOctreeWriter(&single, OctreeHeader{Format: MipR8G8B8A8UnpackUI32, VoxelsPerAxis: 1, Bounds: Box{Size: 2}}, nil)
+	if err != nil {
+		panic(err)
+	}
+	if err := w.WriteNode(Color{1, 0, 0, 1}, nil, make([]uint64, 8)); err != nil {
+		panic(err)
+	}
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+
+	var obj bytes.Buffer
+	status, err := ExportMesh(bytes.NewReader(single.Bytes()), &obj, &MeshConfig{Format: MeshOBJ, Depth: -1})
+	if err != nil {
+		panic(err)
+	}
+
+	if status.NumVertices != 8 || status.NumFaces != 12 || strings.Contains(obj.String(), "v 2 2 2 1.0000 0.0000 0.0000\n") == false {
+		panic(fmt.Errorf("unexpected cube mesh: %+v\n%v", status, obj.String()))
+	}
+	testMeshEdges(obj.Bytes())
+
+	cfg := testBuildConfig(MipR8G8B8A8UnpackUI32, &built)
+	cfg.Optimize = false
+	if _, err := BuildTree(&cfg); err != nil {
+		panic(err)
+	}
+
+	for _, mesh := range []MeshConfig{{MeshOBJ, -1, 0}, {MeshOBJ, 2, 0}, {MeshOBJ, -1, 3}, {MeshPLY, -1, 2}} {
+		var out bytes.Buffer
+		status, err := ExportMesh(bytes.NewReader(built.Bytes()), &out, &mesh)
+		if err != nil {
+			panic(err)
+		}
+
+		if mesh.Format == MeshPLY {
+			end := strings.Index(out.String(), "end_header\n") + len("end_header\n")
+			if out.Len()-end != status.NumVertices*(3*8+3)+status.NumFaces*(1+3*4) {
+				panic("invalid binary ply")
+			}
+			continue
+		}
+
+		vertices, faces := testMeshEdges(out.Bytes())
+		if vertices != status.NumVertices || faces != status.NumFaces {
+			panic(fmt.Errorf("mesh %+v: %v vertices and %v faces, expected %+v", mesh, vertices, faces, status))
+		}
+	}
+}
+
+func TestExportMeshTransform(t *testing.T) {
+	var single bytes.Buffer
+
+	// Mirrors X and moves the cube from 8..10 to 0..2 in the tree.
+	header := OctreeHeader{Format: MipR8G8B8A8UnpackUI32, VoxelsPerAxis: 1, Bounds: Box{Size: 2}}
+	header.Transform = [16]float64{-1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1, 0, 10, 0, 0, 1}
+
+	w, err := NewOctreeWriter(&single, header, nil)
+	if err != nil {
+		panic(err)
+	}
+	if err := w.WriteNode(Color{1, 0, 0, 1}, nil, make([]uint64, 8)); err != nil {
+		panic(err)
+	}
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+
+	var obj bytes.Buffer
+	if _, err := ExportMesh(bytes.NewReader(single.Bytes()), &obj, &MeshConfig{Format: MeshOBJ, Depth: -1}); err != nil {
+		panic(err)
+	}
+	testMeshEdges(obj.Bytes())
+
+	var (
+		vertices [][3]float64
+		volume   float64
+	)
+
+	scanner := bufio.NewScanner(bytes.NewReader(obj.Bytes()))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "v ") {
+			var v [3]float64
+			if _, err := fmt.Sscan(line[2:], &v[0], &v[1], &v[2]); err != nil {
+				panic(err)
+			}
+			if v[0] < 8 || v[0] > 10 {
+				panic(fmt.Errorf("vertex %v is not in source coordinates", v))
+			}
+			vertices = append(vertices, v)
+		} else if strings.HasPrefix(line, "f ") {
+			var f [3]int
+			if _, err := fmt.Sscanf(line, "f %d %d %d", &f[0], &f[1], &f[2]); err != nil {
+				panic(err)
+			}
+
+			a, b, c := vertices[f[0]-1], vertices[f[1]-1], vertices[f[2]-1]
+			volume += (a[0]*(b[1]*c[2]-b[2]*c[1]) - a[1]*(b[0]*c[2]-b[2]*c[0]) + a[2]*(b[0]*c[1]-b[1]*c[0])) / 6
+		}
+	}
+
+	// Faces point outwards when the volume is positive.
+	if volume < 7.99 || volume > 8.01 {
+		panic(fmt.Errorf("mirrored cube has volume %v, expected 8", volume))
+	}
+}
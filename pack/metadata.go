@@ -0,0 +1,201 @@
+/*
+Copyright (C) 2015-2016 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package pack
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+)
+
+// The metadata section starts with a uint32 where the low 24 bits hold the
+// length of the metadata and the high 8 bits the number of padding bytes
+// after it. The padding aligns the end of the header, and so the nodes, to
+// metadataAlign bytes.
+const (
+	maxMetadataSize = 1<<24 - 1
+	metadataAlign   = 8
+)
+
+// Metadata holds key/value pairs describing a tree, such as its source files,
+// coordinate reference system or units. It is stored as JSON in the header.
+type Metadata map[string]string
+
+// DecodeMetadata returns the metadata of the header, it is empty if the tree
+// has none.
+func (h *OctreeHeader) DecodeMetadata() (Metadata, error) {
+	meta := make(Metadata)
+	if len(h.Metadata) == 0 {
+		return meta, nil
+	}
+
+	if err := json.Unmarshal(h.Metadata, &meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// EncodeMetadata replaces the metadata of the header, an empty map removes it.
+func (h *OctreeHeader) EncodeMetadata(meta Metadata) error {
+	if len(meta) == 0 {
+		h.Metadata = nil
+		return nil
+	}
+
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	if len(data) > maxMetadataSize {
+		return errMetadata
+	}
+
+	h.Metadata = data
+	return nil
+}
+
+func (h *OctreeHeader) metadataSize() int {
+	if h.Version < 4 {
+		return 0
+	}
+	return 4 + len(h.Metadata) + h.metadataPadding()
+}
+
+func (h *OctreeHeader) metadataPadding() int {
+	size := h.fieldsSize() + h.farTableSize() + 4 + len(h.Metadata)
+	return (metadataAlign - size%metadataAlign) % metadataAlign
+}
+
+func decodeMetadataSection(reader io.Reader, header *OctreeHeader) error {
+	var section uint32
+	if err := binary.Read(reader, header.ByteOrder(), &section); err != nil {
+		return err
+	}
+
+	if size := section & maxMetadataSize; size > 0 {
+		header.Metadata = make([]byte, size)
+		if _, err := io.ReadFull(reader, header.Metadata); err != nil {
+			return err
+		}
+	}
+
+	padding := int(section >> 24)
+	if padding != header.metadataPadding() {
+		return errMetadata
+	}
+
+	_, err := io.CopyN(ioutil.Discard, reader, int64(padding))
+	return err
+}
+
+func encodeMetadataSection(writer io.Writer, header *OctreeHeader) error {
+	if header.Version < 4 {
+		if len(header.Metadata) > 0 {
+			return errMetadata
+		}
+		return nil
+	}
+
+	if len(header.Metadata) > maxMetadataSize {
+		return errMetadata
+	}
+
+	padding := header.metadataPadding()
+	section := uint32(padding)<<24 | uint32(len(header.Metadata))
+	if err := binary.Write(writer, header.ByteOrder(), section); err != nil {
+		return err
+	}
+
+	if _, err := writer.Write(header.Metadata); err != nil {
+		return err
+	}
+
+	_, err := writer.Write(make([]byte, padding))
+	return err
+}
+
+// ReadMetadata returns the metadata of the tree in reader.
+func ReadMetadata(reader io.Reader) (Metadata, error) {
+	var header OctreeHeader
+	if err := DecodeHeader(reader, &header); err != nil {
+		return nil, err
+	}
+	return header.DecodeMetadata()
+}
+
+// WriteMetadata copies the tree in reader to writer with its metadata
+// replaced by meta. The node data is copied as is, the block table of a
+// blocked tree is moved by the change in header size. The node counts of a
+// tree with a trailer are moved to the header and the trailer is dropped.
+func WriteMetadata(reader io.Reader, writer io.Writer, meta Metadata) error {
+	var trailer bool
+	if seeker, ok := reader.(io.ReadSeeker); ok == true {
+		var err error
+		if trailer, err = hasTrailer(seeker); err != nil {
+			return err
+		}
+	}
+
+	var header OctreeHeader
+	if err := DecodeHeader(reader, &header); err != nil {
+		return err
+	}
+
+	nodes := reader
+	if trailer == true {
+		size, err := trailerDataSize(reader.(io.ReadSeeker))
+		if err != nil {
+			return err
+		}
+		nodes = io.LimitReader(reader, size)
+	}
+
+	var offsets []uint64
+	if header.Blocked() == true {
+		var err error
+		if offsets, err = readBlockTable(reader, &header); err != nil {
+			return err
+		}
+	}
+
+	outputHeader := header
+	outputHeader.Version = binaryVersion
+	if err := outputHeader.EncodeMetadata(meta); err != nil {
+		return err
+	}
+
+	if err := EncodeHeader(writer, outputHeader); err != nil {
+		return err
+	}
+
+	if offsets != nil {
+		delta := uint64(outputHeader.Size() - header.Size())
+		for i := range offsets {
+			offsets[i] += delta
+		}
+
+		if err := binary.Write(writer, outputHeader.ByteOrder(), offsets); err != nil {
+			return err
+		}
+	}
+
+	_, err := io.Copy(writer, nodes)
+	return err
+}
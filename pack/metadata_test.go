@@ -0,0 +1,209 @@
+/*
+Copyright (C) 2015-2016 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package pack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testMetadata(data []byte, meta Metadata) {
+	m, err := ReadMetadata(bytes.NewReader(data))
+	if err != nil {
+		panic(err)
+	}
+
+	if reflect.DeepEqual(m, meta) == false {
+		panic(fmt.Errorf("metadata %v != %v", m, meta))
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	meta := Metadata{"source": "test.xyz", "units": "m", "crs": "EPSG:3006"}
+
+	var buffer bytes.Buffer
+	cfg := testBuildConfig(MipR8G8B8A8UnpackUI32, &buffer)
+	cfg.Metadata = meta
+	if _, err := BuildTree(&cfg); err != nil {
+		panic(err)
+	}
+
+	data := buffer.Bytes()
+	testMetadata(data, meta)
+
+	var transcoded, compressed, optimized, cleared bytes.Buffer
+	if err := TranscodeTree(bytes.NewReader(data), &transcoded, MipR8G8B8A8PackRelUI28, binary.BigEndian); err != nil {
+		panic(err)
+	}
+	testMetadata(transcoded.Bytes(), meta)
+
+	if err := CompressTree(bytes.NewReader(transcoded.Bytes()), &compressed, NewGzipCodec(-1)); err != nil {
+		panic(err)
+	}
+	testMetadata(compressed.Bytes(), meta)
+
+	if _, err := OptimizeTree(bytes.NewReader(compressed.Bytes()), &optimized, MipP8PackUI31, 0.25, false); err != nil {
+		panic(err)
+	}
+	testMetadata(optimized.Bytes(), meta)
+
+	reader, err := NewOctreeReader(bytes.NewReader(optimized.Bytes()))
+	if err != nil {
+		panic(err)
+	}
+	if reader.NumNodes() == 0 {
+		panic("optimized tree is empty")
+	}
+	reader.Close()
+
+	if err := WriteMetadata(bytes.NewReader(data), &cleared, nil); err != nil {
+		panic(err)
+	}
+	testMetadata(cleared.Bytes(), Metadata{})
+
+	header, colors, children := decodeTestTree(data)
+	_, c, ch := decodeTestTree(cleared.Bytes())
+	if reflect.DeepEqual(colors, c) == false || reflect.DeepEqual(children, ch) == false {
+		panic("nodes changed when writing metadata")
+	}
+
+	blocked, err := ioutil.TempFile("", "")
+	if err != nil {
+		panic(err)
+	}
+	defer removeTempFile(blocked)
+
+	if err := CompressTreeBlocks(bytes.NewReader(data), blocked, NewZlibCodec(-1), 2); err != nil {
+		panic(err)
+	}
+
+	for _, m := range []Metadata{nil, {"source": "a much longer source name than the original.xyz"}} {
+		if _, err := blocked.Seek(0, 0); err != nil {
+			panic(err)
+		}
+
+		rewritten, err := ioutil.TempFile("", "")
+		if err != nil {
+			panic(err)
+		}
+		defer removeTempFile(rewritten)
+
+		if err := WriteMetadata(blocked, rewritten, m); err != nil {
+			panic(err)
+		}
+
+		if _, err := rewritten.Seek(0, 0); err != nil {
+			panic(err)
+		}
+
+		testBlockedTree(rewritten, MipR8G8B8A8UnpackUI32, header, colors, children)
+
+		if _, err := rewritten.Seek(0, 0); err != nil {
+			panic(err)
+		}
+
+		if m == nil {
+			m = Metadata{}
+		}
+
+		read, err := ReadMetadata(rewritten)
+		if err != nil {
+			panic(err)
+		}
+
+		if reflect.DeepEqual(read, m) == false {
+			panic(fmt.Errorf("metadata %v != %v", read, m))
+		}
+	}
+}
+
+func TestMetadataAlignment(t *testing.T) {
+	for n := 0; n < 16; n++ {
+		header := OctreeHeader{Sign: octreeSignature, Version: binaryVersion, Format: MipR8G8B8A8PackRelUI28, NumNodes: 1, FarPointers: make([]uint64, n%3)}
+		if err := header.EncodeMetadata(Metadata{"source": strings.Repeat("a", n)}); err != nil {
+			panic(err)
+		}
+
+		var buffer bytes.Buffer
+		if err := EncodeHeader(&buffer, header); err != nil {
+			panic(err)
+		}
+
+		if buffer.Len() != header.Size() || buffer.Len()%metadataAlign != 0 {
+			panic(fmt.Errorf("header of %v bytes is not aligned", buffer.Len()))
+		}
+
+		var decoded OctreeHeader
+		if err := DecodeHeader(&buffer, &decoded); err != nil {
+			panic(err)
+		}
+
+		if bytes.Equal(decoded.Metadata, header.Metadata) == false || buffer.Len() != 0 {
+			panic("metadata padding was not decoded")
+		}
+	}
+}
+
+func TestMetadataTrailer(t *testing.T) {
+	var buffer bytes.Buffer
+	w, err := NewOctreeWriter(&buffer, OctreeHeader{Format: MipR8G8B8A8UnpackUI32, VoxelsPerAxis: 1}, nil)
+	if err != nil {
+		panic(err)
+	}
+	if err := w.WriteNode(Color{1, 0, 0, 1}, nil, make([]uint64, 8)); err != nil {
+		panic(err)
+	}
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+
+	meta := Metadata{"source": "stream"}
+	var rewritten bytes.Buffer
+	if err := WriteMetadata(bytes.NewReader(buffer.Bytes()), &rewritten, meta); err != nil {
+		panic(err)
+	}
+
+	// The counts are in the header, so the tree can be read without seeking.
+	reader := bytes.NewBuffer(rewritten.Bytes())
+	var header OctreeHeader
+	if err := DecodeHeader(reader, &header); err != nil {
+		panic(err)
+	}
+
+	if header.NumNodes != 1 || header.NumLeafs != 1 || header.Flags&trailerMask != 0 {
+		panic(fmt.Errorf("invalid header: %+v", header))
+	}
+
+	var (
+		color    Color
+		children [8]uint64
+	)
+	if err := DecodeNode(reader, &header, 0, &color, nil, children[:]); err != nil {
+		panic(err)
+	}
+
+	if color != (Color{1, 0, 0, 1}) || reader.Len() != 0 {
+		panic("trailer was copied with the nodes")
+	}
+	testMetadata(rewritten.Bytes(), meta)
+}
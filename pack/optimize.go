@@ -18,8 +18,6 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>.
 package pack
 
 import (
-	"compress/zlib"
-	"encoding/binary"
 	"io"
 	"io/ioutil"
 	"math"
@@ -29,22 +27,29 @@ import (
 const leafThreshold = 0 // Should perhaps move this to be controlled by the user.
 
 type OptStatus struct {
-	NumMerged uint32
+	NumMerged uint64
 	MemMap    []int64
+
+	// DedupRatio is the number of input nodes per output node of
+	// DeduplicateTree.
+	DedupRatio float64
 }
 
 type optInput struct {
 	reader         io.ReadSeeker
 	files          []*os.File
 	header         *OctreeHeader
+	tempHeader     *OctreeHeader
 	colorThreshold float32
 	colorFilter    bool
 	status         *OptStatus
 }
 
-func CompressTree(reader io.Reader, writer io.Writer) error {
+// CompressTree compresses the node data of an uncompressed tree with codec.
+// Compressing with the CodecNone codec produces an uncompressed copy.
+func CompressTree(reader io.Reader, writer io.Writer, codec Codec) error {
 	var header OctreeHeader
-	err := binary.Read(reader, binary.LittleEndian, &header)
+	err := DecodeHeader(reader, &header)
 	if err != nil {
 		return err
 	}
@@ -52,32 +57,90 @@ func CompressTree(reader io.Reader, writer io.Writer) error {
 	if header.Compressed() == true {
 		return errInputIsCompressed
 	}
-	header.Flags = header.Flags & compressedMask
 
-	err = binary.Write(writer, binary.LittleEndian, header)
+	outputHeader := header
+	if codec.ID() != CodecNone {
+		outputHeader.Codec = codec.ID()
+		outputHeader.Flags |= compressedMask
+	}
+
+	return recodeNodes(reader, writer, header, outputHeader, codec)
+}
+
+func DecompressTree(reader io.Reader, writer io.Writer) error {
+	var header OctreeHeader
+	err := DecodeHeader(reader, &header)
 	if err != nil {
 		return err
 	}
 
-	zip := zlib.NewWriter(writer)
-	defer zip.Close()
+	if header.Compressed() == false {
+		return errInputIsUncompressed
+	}
 
-	var (
-		color    Color
-		children [8]uint32
-	)
+	outputHeader := header
+	outputHeader.Flags &^= compressedMask | blockedMask
+	outputHeader.BlockSize = 0
 
-	for i := uint64(0); i < header.NumNodes; i++ {
-		if err := DecodeNode(reader, header.Format, &color, children[:]); err != nil {
-			return err
-		}
+	return recodeNodes(reader, writer, header, outputHeader, noneCodec{})
+}
 
-		if err := EncodeNode(zip, header.Format, color, children[:]); err != nil {
-			return err
-		}
+// decompressToTempFile decompresses the tree in reader into a temporary file.
+// The caller is responsible for removing the file.
+func decompressToTempFile(reader io.Reader) (*os.File, error) {
+	fp, err := ioutil.TempFile("", "")
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	if err := DecompressTree(reader, fp); err != nil {
+		removeTempFile(fp)
+		return nil, err
+	}
+
+	if _, err := fp.Seek(0, 0); err != nil {
+		removeTempFile(fp)
+		return nil, err
+	}
+
+	return fp, nil
+}
+
+// plainTempFile copies the nodes that follow the already decoded header in
+// reader to an uncompressed temporary file. The caller is responsible for
+// removing the file.
+func plainTempFile(reader io.Reader, header OctreeHeader) (*os.File, error) {
+	nodeReader, err := NewNodeReader(reader, &header)
+	if err != nil {
+		return nil, err
+	}
+	defer nodeReader.Close()
+
+	fp, err := ioutil.TempFile("", "")
+	if err != nil {
+		return nil, err
+	}
+
+	header.Flags &^= compressedMask | blockedMask
+	header.BlockSize = 0
+
+	if err := EncodeHeader(fp, header); err != nil {
+		removeTempFile(fp)
+		return nil, err
+	}
+
+	if _, err := io.Copy(fp, nodeReader); err != nil {
+		removeTempFile(fp)
+		return nil, err
+	}
+
+	return fp, nil
+}
+
+func removeTempFile(fp *os.File) {
+	name := fp.Name()
+	fp.Close()
+	os.Remove(name)
 }
 
 func OptimizeTree(reader io.ReadSeeker, writer io.Writer, outputFormat OctreeFormat, colorThreshold float32, colorFilter bool) (OptStatus, error) {
@@ -86,12 +149,25 @@ func OptimizeTree(reader io.ReadSeeker, writer io.Writer, outputFormat OctreeFor
 		status OptStatus
 	)
 
-	if err := binary.Read(reader, binary.LittleEndian, &header); err != nil {
+	if err := DecodeHeader(reader, &header); err != nil {
 		return status, err
 	}
 
 	if header.Compressed() == true {
-		return status, errInputIsCompressed
+		if _, err := reader.Seek(0, 0); err != nil {
+			return status, err
+		}
+
+		fp, err := decompressToTempFile(reader)
+		if err != nil {
+			return status, err
+		}
+		defer removeTempFile(fp)
+
+		reader = fp
+		if err := DecodeHeader(reader, &header); err != nil {
+			return status, err
+		}
 	}
 
 	maxLevels := 0
@@ -111,29 +187,49 @@ func OptimizeTree(reader io.ReadSeeker, writer io.Writer, outputFormat OctreeFor
 	}
 	defer func() {
 		for _, fp := range tempFiles {
-			name := fp.Name()
-			fp.Close()
-			os.Remove(name)
+			removeTempFile(fp)
 		}
 	}()
 
 	header.NumLeafs = 0
 	header.NumNodes = 0
-	header.Flags &= optimizedMask
 
-	args := optInput{reader, tempFiles, &header, colorThreshold, colorFilter, &status}
+	// Layout of the per-level temporary files written by optNode.
+	tempHeader := OctreeHeader{Format: MipR8G8B8A8UnpackUI64, Channels: header.Channels}
+
+	args := optInput{reader, tempFiles, &header, &tempHeader, colorThreshold, colorFilter, &status}
 	_, err := optNode(&args, 0, 0, Color{})
 	if err != nil {
 		return status, err
 	}
 
+	header.Version = binaryVersion
 	header.Format = outputFormat
-	if err := binary.Write(writer, binary.LittleEndian, header); err != nil {
+	header.Flags |= optimizedMask
+	header.Flags &^= dagMask
+	header.ColorThreshold = colorThreshold
+	if colorFilter == true {
+		header.Flags |= filteredMask
+	}
+
+	if outputFormat.Paletted() == true {
+		if header.Palette, err = filesPalette(tempFiles, &tempHeader); err != nil {
+			return status, err
+		}
+	}
+
+	header.FarPointers = nil
+	if outputFormat.Relative() == true {
+		if header.FarPointers, err = filesFarPointers(tempFiles, &tempHeader, outputFormat); err != nil {
+			return status, err
+		}
+	}
+
+	if err := EncodeHeader(writer, header); err != nil {
 		return status, err
 	}
 
-	header.Format = MipR8G8B8A8UnpackUI32
-	err = mergeAndPatch(writer, tempFiles, &header, outputFormat, &status)
+	err = mergeAndPatch(writer, tempFiles, &header, &tempHeader, &status)
 	if err != nil {
 		return status, err
 	}
@@ -141,82 +237,121 @@ func OptimizeTree(reader io.ReadSeeker, writer io.Writer, outputFormat OctreeFor
 	return status, err
 }
 
-func mergeAndPatch(writer io.Writer, files []*os.File, header *OctreeHeader, outputFormat OctreeFormat, status *OptStatus) error {
-	var numNodes int64
-	for lv, fp := range files {
-		var (
-			color    Color
-			children [8]uint32
-		)
+// filesPalette generates a palette from the colors of the nodes in the
+// per-level temporary files.
+func filesPalette(files []*os.File, tempHeader *OctreeHeader) (Palette, error) {
+	builder := newPaletteBuilder()
+	err := mergedNodes(files, tempHeader, func(index uint64, color Color, attr *Attributes, children []uint64) error {
+		builder.add(color)
+		return nil
+	})
+	return builder.palette(), err
+}
+
+// filesFarPointers returns the far pointer table needed to store the nodes
+// in the per-level temporary files in format.
+func filesFarPointers(files []*os.File, tempHeader *OctreeHeader, format OctreeFormat) ([]uint64, error) {
+	table := make(farTable)
+	err := mergedNodes(files, tempHeader, func(index uint64, color Color, attr *Attributes, children []uint64) error {
+		table.add(format, index, children)
+		return nil
+	})
+	return table.pointers(), err
+}
 
+func mergeAndPatch(writer io.Writer, files []*os.File, header, tempHeader *OctreeHeader, status *OptStatus) error {
+	offset := int64(header.Size())
+	for lv, fp := range files {
 		end, err := fp.Seek(0, 2)
 		if err != nil {
-			return nil
+			return err
 		}
 
-		_, err = fp.Seek(0, 0)
+		status.MemMap[lv] = offset
+		offset += end / int64(tempHeader.NodeSize()) * int64(header.NodeSize())
+	}
+
+	return mergedNodes(files, tempHeader, func(index uint64, color Color, attr *Attributes, children []uint64) error {
+		return EncodeNode(writer, header, index, color, attr, children)
+	})
+}
+
+// mergedNodes calls fn for every node in the per-level temporary files, in
+// output order and with the children patched to output indices.
+func mergedNodes(files []*os.File, tempHeader *OctreeHeader, fn func(index uint64, color Color, attr *Attributes, children []uint64) error) error {
+	var (
+		numNodes uint64
+		color    Color
+		attr     Attributes
+		children [8]uint64
+	)
+
+	for _, fp := range files {
+		end, err := fp.Seek(0, 2)
 		if err != nil {
-			return nil
+			return err
 		}
 
-		nodeSize := int64(header.Format.NodeSize())
-		numNodesInFile := end / nodeSize
+		if _, err := fp.Seek(0, 0); err != nil {
+			return err
+		}
+
+		numNodesInFile := uint64(end / int64(tempHeader.NodeSize()))
 		nextLevelStart := numNodes + numNodesInFile
 
-		for i := int64(0); i < numNodesInFile; i++ {
-			if err := DecodeNode(fp, header.Format, &color, children[:]); err != nil {
+		for i := uint64(0); i < numNodesInFile; i++ {
+			if err := DecodeNode(fp, tempHeader, i, &color, &attr, children[:]); err != nil {
 				return err
 			}
 
 			for j, child := range children {
-				if child == math.MaxUint32 {
+				if child == math.MaxUint64 {
 					children[j] = 0
 				} else {
-					children[j] = uint32(nextLevelStart) + child
+					children[j] = nextLevelStart + child
 				}
 			}
 
-			if err := EncodeNode(writer, outputFormat, color, children[:]); err != nil {
+			if err := fn(numNodes+i, color, &attr, children[:]); err != nil {
 				return err
 			}
 		}
-
-		status.MemMap[lv] = numNodes*nodeSize + int64(header.Size())
 		numNodes += numNodesInFile
 	}
 	return nil
 }
 
-func optNode(in *optInput, nodeIndex, level uint32, parentColor Color) (int64, error) {
+func optNode(in *optInput, nodeIndex uint64, level uint32, parentColor Color) (int64, error) {
 	var (
 		color    Color
-		children [8]uint32
+		attr     Attributes
+		children [8]uint64
 	)
 
-	nodeSize := in.header.Format.NodeSize()
-	headerSize := uint32(in.header.Size())
+	nodeSize := int64(in.header.NodeSize())
+	headerSize := int64(in.header.Size())
 
-	if _, err := in.reader.Seek(int64(nodeIndex*uint32(nodeSize)+headerSize), 0); err != nil {
+	if _, err := in.reader.Seek(int64(nodeIndex)*nodeSize+headerSize, 0); err != nil {
 		return 0, err
 	}
 
-	if err := DecodeNode(in.reader, in.header.Format, &color, children[:]); err != nil {
+	if err := DecodeNode(in.reader, in.header, nodeIndex, &color, &attr, children[:]); err != nil {
 		return 0, err
 	}
 
 	merge := true
 	for _, child := range children {
 		if child > 0 {
-			if _, err := in.reader.Seek(int64(child*uint32(nodeSize)+headerSize), 0); err != nil {
+			if _, err := in.reader.Seek(int64(child)*nodeSize+headerSize, 0); err != nil {
 				return 0, err
 			}
 
 			var (
 				childColor    Color
-				grandChildren [8]uint32
+				grandChildren [8]uint64
 			)
 
-			if err := DecodeNode(in.reader, in.header.Format, &childColor, grandChildren[:]); err != nil {
+			if err := DecodeNode(in.reader, in.header, child, &childColor, nil, grandChildren[:]); err != nil {
 				return 0, err
 			}
 
@@ -250,16 +385,16 @@ func optNode(in *optInput, nodeIndex, level uint32, parentColor Color) (int64, e
 				if err != nil {
 					return 0, err
 				}
-				children[i] = uint32(p)
+				children[i] = uint64(p)
 				numChildren++
 			} else {
-				children[i] = math.MaxUint32
+				children[i] = math.MaxUint64
 			}
 		}
 	} else {
 		in.status.NumMerged++
 		for i := range children {
-			children[i] = math.MaxUint32
+			children[i] = math.MaxUint64
 		}
 	}
 
@@ -278,9 +413,10 @@ func optNode(in *optInput, nodeIndex, level uint32, parentColor Color) (int64, e
 		}
 	}
 
-	if err := EncodeNode(fp, MipR8G8B8A8UnpackUI32, newColor, children[:]); err != nil {
+	index := pos / int64(in.tempHeader.NodeSize())
+	if err := EncodeNode(fp, in.tempHeader, uint64(index), newColor, &attr, children[:]); err != nil {
 		return 0, err
 	}
 
-	return pos / int64(MipR8G8B8A8UnpackUI32.NodeSize()), nil
+	return index, nil
 }
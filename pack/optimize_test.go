@@ -18,6 +18,10 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>.
 package pack
 
 import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
 	"os"
 	"testing"
 )
@@ -31,7 +35,7 @@ func TestTranscode(t *testing.T) {
 	out, _ := os.Create("test.opt")
 	defer out.Close()
 
-	if err := TranscodeTree(in, out, MipR8G8B8A8UnpackUI32); err != nil {
+	if err := TranscodeTree(in, out, MipR8G8B8A8UnpackUI32, binary.LittleEndian); err != nil {
 		panic(err)
 	}
 }
@@ -45,7 +49,67 @@ func TestCompress(t *testing.T) {
 	out, _ := os.Create("test.ocz")
 	defer out.Close()
 
-	if err := CompressTree(in, out); err != nil {
+	if err := CompressTree(in, out, NewZlibCodec(zlib.DefaultCompression)); err != nil {
 		panic(err)
 	}
 }
+
+func TestCompressRoundTrip(t *testing.T) {
+	for _, format := range testFormats {
+		var compressed, decompressed bytes.Buffer
+
+		data := buildTestTree(format)
+		if err := CompressTree(bytes.NewReader(data), &compressed, NewZlibCodec(zlib.DefaultCompression)); err != nil {
+			panic(err)
+		}
+
+		if err := DecompressTree(bytes.NewReader(compressed.Bytes()), &decompressed); err != nil {
+			panic(err)
+		}
+
+		if bytes.Equal(data, decompressed.Bytes()) == false {
+			panic(fmt.Errorf("format %v did not round-trip", format))
+		}
+
+		header, colors, children := decodeTestTree(data)
+
+		reader, err := NewOctreeReader(bytes.NewReader(compressed.Bytes()))
+		if err != nil {
+			panic(err)
+		}
+
+		var (
+			color Color
+			ch    [8]uint64
+		)
+
+		for i := range colors {
+			if err := reader.ReadNode(uint64(i), &color, nil, ch[:]); err != nil {
+				panic(err)
+			}
+
+			if color != colors[i] || ch != children[i] {
+				panic(fmt.Errorf("node %v mismatch in format %v", i, format))
+			}
+		}
+		reader.Close()
+
+		report, err := Validate(bytes.NewReader(compressed.Bytes()))
+		if err != nil {
+			panic(err)
+		}
+
+		if report.Valid() == false || report.Header.Compressed() == false {
+			panic(fmt.Errorf("compressed tree did not validate: %+v", report))
+		}
+
+		var optimized bytes.Buffer
+		if _, err := OptimizeTree(bytes.NewReader(compressed.Bytes()), &optimized, format, 0, false); err != nil {
+			panic(err)
+		}
+
+		if optimizedHeader, _, _ := decodeTestTree(optimized.Bytes()); optimizedHeader.NumLeafs != header.NumLeafs {
+			panic("optimized compressed tree lost leafs")
+		}
+	}
+}
@@ -0,0 +1,248 @@
+/*
+Copyright (C) 2015-2016 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package pack
+
+import "sort"
+
+// Upper bound on the number of color bins used to generate a palette. When
+// a tree has more distinct colors than this, colors are binned at 5 bits
+// per component.
+const paletteMaxBins = 1 << 16
+
+// Palette holds the RGBA colors of a palette-indexed tree.
+type Palette [256][4]byte
+
+func (p *Palette) Color(index byte) Color {
+	c := p[index]
+	return Color{float32(c[0]) / 255, float32(c[1]) / 255, float32(c[2]) / 255, float32(c[3]) / 255}
+}
+
+// Index returns the index of the palette entry closest to color.
+func (p *Palette) Index(color Color) byte {
+	return p.nearest(color, len(p))
+}
+
+// nearest returns the index of the closest of the first n palette entries.
+func (p *Palette) nearest(color Color, n int) byte {
+	var (
+		index   byte
+		minDist = -1
+	)
+
+	col := color.bytes()
+	for i, entry := range p[:n] {
+		dist := 0
+		for j := range entry {
+			d := int(entry[j]) - int(col[j])
+			dist += d * d
+		}
+
+		if minDist < 0 || dist < minDist {
+			index = byte(i)
+			minDist = dist
+			if dist == 0 {
+				break
+			}
+		}
+	}
+	return index
+}
+
+// treePalette generates a palette from the colors of every node in tree.
+func treePalette(tree *OctreeReader) (Palette, error) {
+	var (
+		color    Color
+		children [8]uint64
+	)
+
+	builder := newPaletteBuilder()
+	for i := uint64(0); i < tree.NumNodes(); i++ {
+		if err := tree.ReadNode(i, &color, nil, children[:]); err != nil {
+			return Palette{}, err
+		}
+		builder.add(color)
+	}
+	return builder.palette(), nil
+}
+
+type paletteBin struct {
+	sum   [4]uint64
+	count uint64
+	color [4]byte
+}
+
+func (b *paletteBin) mean() [4]byte {
+	var c [4]byte
+	for i, s := range b.sum {
+		c[i] = byte(s / b.count)
+	}
+	return c
+}
+
+// paletteBuilder generates a palette by median cut over the colors it is
+// given. A tree with no more than 256 distinct colors gets them exactly.
+type paletteBuilder struct {
+	bins  map[uint32]*paletteBin
+	shift uint
+}
+
+func newPaletteBuilder() *paletteBuilder {
+	return &paletteBuilder{bins: make(map[uint32]*paletteBin)}
+}
+
+func (b *paletteBuilder) key(c [4]byte) uint32 {
+	return uint32(c[0]>>b.shift)<<24 | uint32(c[1]>>b.shift)<<16 | uint32(c[2]>>b.shift)<<8 | uint32(c[3]>>b.shift)
+}
+
+func (b *paletteBuilder) addBin(c [4]byte, bin *paletteBin) {
+	key := b.key(c)
+	dst, ok := b.bins[key]
+	if ok == false {
+		dst = &paletteBin{}
+		b.bins[key] = dst
+	}
+
+	for i := range dst.sum {
+		dst.sum[i] += bin.sum[i]
+	}
+	dst.count += bin.count
+}
+
+func (b *paletteBuilder) add(color Color) {
+	c := color.bytes()
+	b.addBin(c, &paletteBin{sum: [4]uint64{uint64(c[0]), uint64(c[1]), uint64(c[2]), uint64(c[3])}, count: 1})
+
+	if len(b.bins) > paletteMaxBins && b.shift == 0 {
+		bins := b.bins
+		b.bins = make(map[uint32]*paletteBin)
+		b.shift = 3
+
+		for _, bin := range bins {
+			b.addBin(bin.mean(), bin)
+		}
+	}
+}
+
+func (b *paletteBuilder) palette() Palette {
+	return b.paletteSize(256)
+}
+
+// paletteSize returns a palette with at most size entries, the rest are zero.
+func (b *paletteBuilder) paletteSize(size int) Palette {
+	var (
+		pal   Palette
+		boxes [][]*paletteBin
+	)
+
+	// Sort the bins to get the same palette for the same colors.
+	keys := make([]int, 0, len(b.bins))
+	for key := range b.bins {
+		keys = append(keys, int(key))
+	}
+	sort.Ints(keys)
+
+	bins := make([]*paletteBin, len(keys))
+	for i, key := range keys {
+		bins[i] = b.bins[uint32(key)]
+		bins[i].color = bins[i].mean()
+	}
+
+	if len(bins) == 0 {
+		return pal
+	}
+	boxes = append(boxes, bins)
+
+	for len(boxes) < size {
+		split, channel, maxRange := -1, 0, 0
+		for i, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+
+			for ch := 0; ch < 4; ch++ {
+				min, max := 255, 0
+				for _, bin := range box {
+					v := int(bin.color[ch])
+					if v < min {
+						min = v
+					}
+					if v > max {
+						max = v
+					}
+				}
+
+				if max-min > maxRange {
+					split, channel, maxRange = i, ch, max-min
+				}
+			}
+		}
+
+		if split < 0 {
+			break
+		}
+
+		box := boxes[split]
+		sort.Stable(binsByChannel{box, channel})
+
+		var total, count uint64
+		for _, bin := range box {
+			total += bin.count
+		}
+
+		median := 1
+		for i, bin := range box[:len(box)-1] {
+			count += bin.count
+			median = i + 1
+			if count*2 >= total {
+				break
+			}
+		}
+
+		boxes[split] = box[:median]
+		boxes = append(boxes, box[median:])
+	}
+
+	for i, box := range boxes {
+		var sum paletteBin
+		for _, bin := range box {
+			for j := range sum.sum {
+				sum.sum[j] += bin.sum[j]
+			}
+			sum.count += bin.count
+		}
+		pal[i] = sum.mean()
+	}
+	return pal
+}
+
+type binsByChannel struct {
+	bins    []*paletteBin
+	channel int
+}
+
+func (s binsByChannel) Len() int {
+	return len(s.bins)
+}
+
+func (s binsByChannel) Less(i, j int) bool {
+	return s.bins[i].color[s.channel] < s.bins[j].color[s.channel]
+}
+
+func (s binsByChannel) Swap(i, j int) {
+	s.bins[i], s.bins[j] = s.bins[j], s.bins[i]
+}
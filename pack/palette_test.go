@@ -0,0 +1,87 @@
+/*
+Copyright (C) 2015-2016 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package pack
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestPaletteBuilder(t *testing.T) {
+	builder := newPaletteBuilder()
+	colors := make([]Color, 200)
+	for i := range colors {
+		colors[i] = Color{float32(i) / 255, float32(255-i) / 255, 0, 1}
+		builder.add(colors[i])
+		builder.add(colors[i])
+	}
+
+	pal := builder.palette()
+	for _, color := range colors {
+		if pal.Color(pal.Index(color)) != color {
+			panic(fmt.Errorf("%v is not in the palette", color))
+		}
+	}
+
+	rnd := rand.New(rand.NewSource(1))
+	builder = newPaletteBuilder()
+	for i := 0; i < paletteMaxBins*2; i++ {
+		builder.add(Color{rnd.Float32(), rnd.Float32(), rnd.Float32(), 1})
+	}
+
+	pal = builder.palette()
+	for i := 0; i < 1000; i++ {
+		color := Color{rnd.Float32(), rnd.Float32(), rnd.Float32(), 1}
+		if match := pal.Color(pal.Index(color)); match.dist(&color) > 0.2 {
+			panic(fmt.Errorf("%v is too far from %v", match, color))
+		}
+	}
+}
+
+func TestPalettedTree(t *testing.T) {
+	for _, optimize := range []bool{false, true} {
+		var reference, paletted bytes.Buffer
+
+		cfg := testBuildConfig(MipR8G8B8A8UnpackUI32, &reference)
+		cfg.Optimize = optimize
+		if _, err := BuildTree(&cfg); err != nil {
+			panic(err)
+		}
+
+		cfg = testBuildConfig(MipP8PackUI31, &paletted)
+		cfg.Optimize = optimize
+		if _, err := BuildTree(&cfg); err != nil {
+			panic(err)
+		}
+
+		_, colors, children := decodeTestTree(reference.Bytes())
+		header, palColors, palChildren := decodeTestTree(paletted.Bytes())
+
+		if header.Format.Paletted() == false || len(colors) != len(palColors) {
+			panic("paletted build mismatch")
+		}
+
+		for i := range colors {
+			if colors[i] != palColors[i] || children[i] != palChildren[i] {
+				panic(fmt.Errorf("node %v mismatch", i))
+			}
+		}
+	}
+}
@@ -0,0 +1,313 @@
+/*
+Copyright (C) 2015-2016 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package pack
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type pcdField struct {
+	name   string
+	typ    byte
+	size   int
+	count  int
+	offset int
+}
+
+// PCDReader reads the points of a Point Cloud Library PCD file as samples.
+// ASCII and binary data is supported, binary_compressed is not. The x, y, z,
+// rgb or rgba, intensity and normal_x, normal_y, normal_z fields are used and
+// points with NaN coordinates are skipped.
+type PCDReader struct {
+	reader     *bufio.Reader
+	ascii      bool
+	fields     []pcdField
+	recordSize int
+	record     []byte
+	numPoints  uint64
+	read       uint64
+}
+
+// NewPCDReader reads the PCD header.
+func NewPCDReader(reader io.Reader) (*PCDReader, error) {
+	r := &PCDReader{reader: bufio.NewReader(reader)}
+
+	var (
+		width, height uint64
+		hasPoints     bool
+	)
+
+	for {
+		line, err := r.reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		key, values := fields[0], fields[1:]
+		switch key {
+		case "FIELDS":
+			r.fields = make([]pcdField, len(values))
+			for i, name := range values {
+				r.fields[i] = pcdField{name: name, count: 1}
+			}
+		case "SIZE", "TYPE", "COUNT":
+			if len(values) != len(r.fields) {
+				return nil, errInvalidFile
+			}
+
+			for i, v := range values {
+				field := &r.fields[i]
+				if key == "TYPE" {
+					if len(v) != 1 || strings.Contains("IUF", v) == false {
+						return nil, errUnsupportedFormat
+					}
+					field.typ = v[0]
+					continue
+				}
+
+				n, err := strconv.Atoi(v)
+				if err != nil || n < 1 {
+					return nil, errInvalidFile
+				}
+
+				if key == "SIZE" {
+					field.size = n
+				} else {
+					field.count = n
+				}
+			}
+		case "WIDTH", "HEIGHT", "POINTS":
+			if len(values) != 1 {
+				return nil, errInvalidFile
+			}
+
+			n, err := strconv.ParseUint(values[0], 10, 64)
+			if err != nil {
+				return nil, err
+			}
+
+			switch key {
+			case "WIDTH":
+				width = n
+			case "HEIGHT":
+				height = n
+			default:
+				r.numPoints, hasPoints = n, true
+			}
+		case "DATA":
+			if len(values) != 1 {
+				return nil, errInvalidFile
+			}
+
+			switch values[0] {
+			case "ascii":
+				r.ascii = true
+			case "binary":
+			default:
+				return nil, errUnsupportedFormat
+			}
+
+			if hasPoints == false {
+				r.numPoints = width * height
+			}
+			return r, r.layout()
+		}
+	}
+}
+
+func (r *PCDReader) layout() error {
+	if len(r.fields) == 0 {
+		return errInvalidFile
+	}
+
+	for i := range r.fields {
+		field := &r.fields[i]
+		if field.typ == 0 {
+			return errInvalidFile
+		}
+
+		switch {
+		case field.size != 1 && field.size != 2 && field.size != 4 && field.size != 8:
+			return errUnsupportedFormat
+		case field.typ == 'F' && field.size < 4:
+			return errUnsupportedFormat
+		case (field.name == "rgb" || field.name == "rgba") && field.size != 4:
+			return errUnsupportedFormat
+		}
+
+		field.offset = r.recordSize
+		r.recordSize += field.size * field.count
+	}
+
+	r.record = make([]byte, r.recordSize)
+	return nil
+}
+
+// NumPoints returns the number of points in the file, including points with
+// NaN coordinates.
+func (r *PCDReader) NumPoints() uint64 {
+	return r.numPoints
+}
+
+// value decodes a binary value, rgb and rgba fields return their packed bits.
+func (f *pcdField) value(data []byte) float64 {
+	le := binary.LittleEndian
+	switch {
+	case f.name == "rgb" || f.name == "rgba":
+		return float64(le.Uint32(data))
+	case f.typ == 'F' && f.size == 4:
+		return float64(math.Float32frombits(le.Uint32(data)))
+	case f.typ == 'F':
+		return math.Float64frombits(le.Uint64(data))
+	}
+
+	var v uint64
+	switch f.size {
+	case 1:
+		v = uint64(data[0])
+	case 2:
+		v = uint64(le.Uint16(data))
+	case 4:
+		v = uint64(le.Uint32(data))
+	default:
+		v = le.Uint64(data)
+	}
+
+	if f.typ == 'U' {
+		return float64(v)
+	}
+
+	shift := uint(64 - 8*f.size)
+	return float64(int64(v<<shift) >> shift)
+}
+
+// parse decodes an ASCII value, rgb and rgba fields return their packed bits.
+func (f *pcdField) parse(text string) (float64, error) {
+	v, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	if (f.name == "rgb" || f.name == "rgba") && f.typ == 'F' {
+		return float64(math.Float32bits(float32(v))), nil
+	}
+	return v, nil
+}
+
+func (r *PCDReader) readPoint(s *Sample) error {
+	var values []string
+	if r.ascii == true {
+		line, err := r.reader.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return err
+		}
+		values = strings.Fields(line)
+	} else if _, err := io.ReadFull(r.reader, r.record); err != nil {
+		return err
+	}
+
+	*s = Sample{Col: Color{1, 1, 1, 1}}
+
+	var index int
+	for i := range r.fields {
+		field := &r.fields[i]
+
+		var v float64
+		if r.ascii == true {
+			if index+field.count > len(values) {
+				return errInvalidFile
+			}
+
+			var err error
+			if v, err = field.parse(values[index]); err != nil {
+				return err
+			}
+			index += field.count
+		} else {
+			v = field.value(r.record[field.offset:])
+		}
+
+		switch field.name {
+		case "x":
+			s.Pos.X = v
+		case "y":
+			s.Pos.Y = v
+		case "z":
+			s.Pos.Z = v
+		case "rgb", "rgba":
+			bits := uint32(v)
+			s.Col.R = float32((bits>>16)&0xff) / 255
+			s.Col.G = float32((bits>>8)&0xff) / 255
+			s.Col.B = float32(bits&0xff) / 255
+			if field.name == "rgba" {
+				s.Col.A = float32(bits>>24) / 255
+			}
+		case "intensity":
+			s.Attributes.Intensity = float32(v)
+		case "normal_x":
+			s.Attributes.Normal[0] = float32(v)
+		case "normal_y":
+			s.Attributes.Normal[1] = float32(v)
+		case "normal_z":
+			s.Attributes.Normal[2] = float32(v)
+		}
+	}
+	return nil
+}
+
+// Read reads the next point into s. It returns io.EOF after the last point.
+func (r *PCDReader) Read(s *Sample) error {
+	for r.read < r.numPoints {
+		r.read++
+		if err := r.readPoint(s); err != nil {
+			return err
+		}
+
+		if math.IsNaN(s.Pos.X+s.Pos.Y+s.Pos.Z) == false {
+			return nil
+		}
+	}
+	return io.EOF
+}
+
+func writePCDHeader(writer io.Writer, data string, numPoints uint64, intensity bool) error {
+	fields, size, typ, count := "x y z rgb", "4 4 4 4", "F F F F", "1 1 1 1"
+	if intensity == true {
+		fields, size, typ, count = fields+" intensity", size+" 4", typ+" F", count+" 1"
+	}
+
+	_, err := fmt.Fprintf(writer, "# .PCD v0.7 - Point Cloud Data file format\nVERSION 0.7\nFIELDS %v\nSIZE %v\nTYPE %v\nCOUNT %v\nWIDTH %v\nHEIGHT 1\nVIEWPOINT 0 0 0 1 0 0 0\nPOINTS %v\nDATA %v\n",
+		fields, size, typ, count, numPoints, numPoints, data)
+	return err
+}
+
+// pcdColor packs a color the way PCL stores rgb fields, as the bits of a float.
+func pcdColor(color [3]byte) float32 {
+	return math.Float32frombits(uint32(color[0])<<16 | uint32(color[1])<<8 | uint32(color[2]))
+}
@@ -0,0 +1,139 @@
+/*
+Copyright (C) 2015-2016 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package pack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"testing"
+)
+
+func readPCDSamples(data []byte) []Sample {
+	reader, err := NewPCDReader(bytes.NewReader(data))
+	if err != nil {
+		panic(err)
+	}
+
+	var samples []Sample
+	for {
+		var s Sample
+		if err := reader.Read(&s); err == io.EOF {
+			break
+		} else if err != nil {
+			panic(err)
+		}
+		samples = append(samples, s)
+	}
+	return samples
+}
+
+func TestPCDRoundTrip(t *testing.T) {
+	var built bytes.Buffer
+
+	cfg := testBuildConfig(MipR8G8B8A8UnpackUI32, &built)
+	cfg.Channels = ChannelIntensity
+	if _, err := BuildTree(&cfg); err != nil {
+		panic(err)
+	}
+
+	var ply bytes.Buffer
+	if _, err := ExportPoints(bytes.NewReader(built.Bytes()), &ply, PointsPLYBinary, -1); err != nil {
+		panic(err)
+	}
+	expected := readPLYSamples(ply.Bytes())
+
+	for _, format := range []PointFormat{PointsPCDASCII, PointsPCDBinary} {
+		var pcd bytes.Buffer
+		if n, err := ExportPoints(bytes.NewReader(built.Bytes()), &pcd, format, -1); err != nil || n != 7 {
+			panic(fmt.Errorf("exported %v points: %v", n, err))
+		}
+
+		samples := readPCDSamples(pcd.Bytes())
+		if len(samples) != len(expected) {
+			panic(fmt.Errorf("read %v points, expected %v", len(samples), len(expected)))
+		}
+
+		for i, s := range samples {
+			if s != expected[i] {
+				panic(fmt.Errorf("point %v is %v, expected %v", i, s, expected[i]))
+			}
+		}
+	}
+}
+
+func TestPCDReader(t *testing.T) {
+	data := "# .PCD v0.7\nVERSION 0.7\nFIELDS x y z _ rgba intensity\nSIZE 4 4 4 1 4 4\nTYPE F F F U U F\nCOUNT 1 1 1 2 1 1\n" +
+		"WIDTH 3\nHEIGHT 1\nVIEWPOINT 0 0 0 1 0 0 0\nPOINTS 3\nDATA ascii\n" +
+		"1 2 3 0 0 2147483392 0.5\n" +
+		"nan nan nan 0 0 0 0\n" +
+		"-1 -2 -3 0 0 4278190335 1\n"
+
+	samples := readPCDSamples([]byte(data))
+	expected := []Sample{
+		{Pos: Point{1, 2, 3}, Col: Color{1, 1, 0, float32(0x7f) / 255}},
+		{Pos: Point{-1, -2, -3}, Col: Color{0, 0, 1, 1}},
+	}
+	expected[0].Attributes.Intensity = 0.5
+	expected[1].Attributes.Intensity = 1
+
+	if len(samples) != 2 {
+		panic(fmt.Errorf("read %v points, expected 2", len(samples)))
+	}
+
+	for i, s := range samples {
+		if s != expected[i] {
+			panic(fmt.Errorf("point %v is %v, expected %v", i, s, expected[i]))
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("FIELDS x y z rgb normal_x\nSIZE 8 8 8 4 2\nTYPE F F F F I\nWIDTH 1\nHEIGHT 1\nDATA binary\n")
+	binary.Write(&buf, binary.LittleEndian, []float64{1.5, -2.5, 1e9})
+	binary.Write(&buf, binary.LittleEndian, math.Float32frombits(0x00ff8000))
+	binary.Write(&buf, binary.LittleEndian, int16(-1))
+
+	samples = readPCDSamples(buf.Bytes())
+	binaryExpected := Sample{Pos: Point{1.5, -2.5, 1e9}, Col: Color{1, float32(0x80) / 255, 0, 1}}
+	binaryExpected.Attributes.Normal[0] = -1
+
+	if len(samples) != 1 || samples[0] != binaryExpected {
+		panic(fmt.Errorf("read %v, expected %v", samples, binaryExpected))
+	}
+}
+
+func TestPCDReaderInvalid(t *testing.T) {
+	headers := []string{
+		"FIELDS x y z\nSIZE 4 4 4\nTYPE F F F\nPOINTS 1\nDATA binary_compressed\n",
+		"FIELDS x y z\nSIZE 4 4\nTYPE F F F\nPOINTS 1\nDATA ascii\n",
+		"FIELDS x y z\nSIZE 4 4 2\nTYPE F F F\nPOINTS 1\nDATA ascii\n",
+		"FIELDS x y z rgb\nSIZE 4 4 4 2\nTYPE F F F U\nPOINTS 1\nDATA ascii\n",
+		"FIELDS x y z\nSIZE 4 4 4\nTYPE F F Q\nPOINTS 1\nDATA ascii\n",
+		"FIELDS x y z\nSIZE 4 4 4\nPOINTS 1\nDATA ascii\n",
+		"FIELDS x y z\nSIZE 4 4 4\nTYPE F F F\nPOINTS 1\n",
+	}
+
+	for _, header := range headers {
+		if _, err := NewPCDReader(strings.NewReader(header)); err == nil {
+			panic(fmt.Errorf("accepted invalid header %q", header))
+		}
+	}
+}
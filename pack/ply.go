@@ -0,0 +1,299 @@
+/*
+Copyright (C) 2015-2016 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package pack
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var plyTypeSizes = map[string]int{
+	"char": 1, "int8": 1, "uchar": 1, "uint8": 1,
+	"short": 2, "int16": 2, "ushort": 2, "uint16": 2,
+	"int": 4, "int32": 4, "uint": 4, "uint32": 4,
+	"float": 4, "float32": 4, "double": 8, "float64": 8,
+}
+
+type plyProperty struct {
+	name      string
+	typ       string
+	countType string // Set for list properties.
+}
+
+type plyElement struct {
+	name       string
+	count      uint64
+	properties []plyProperty
+}
+
+// PLYReader reads the vertices of a PLY point cloud as samples. The x, y, z,
+// red, green, blue, alpha, intensity and nx, ny, nz vertex properties are
+// used, colors default to white.
+type PLYReader struct {
+	reader   *bufio.Reader
+	order    binary.ByteOrder
+	ascii    bool
+	fields   []string
+	elements []plyElement
+	vertex   int
+	read     uint64
+	buffer   [8]byte
+}
+
+// NewPLYReader reads the PLY header and skips any elements stored before the
+// vertices.
+func NewPLYReader(reader io.Reader) (*PLYReader, error) {
+	r := &PLYReader{reader: bufio.NewReader(reader), vertex: -1}
+
+	line, err := r.headerLine()
+	if err != nil {
+		return nil, err
+	}
+
+	if line != "ply" {
+		return nil, errInvalidSignature
+	}
+
+	for {
+		line, err := r.headerLine()
+		if err != nil {
+			return nil, err
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "format":
+			if len(fields) < 2 {
+				return nil, errInvalidFile
+			}
+
+			switch fields[1] {
+			case "ascii":
+				r.ascii = true
+			case "binary_little_endian":
+				r.order = binary.LittleEndian
+			case "binary_big_endian":
+				r.order = binary.BigEndian
+			default:
+				return nil, errUnsupportedFormat
+			}
+		case "element":
+			if len(fields) != 3 {
+				return nil, errInvalidFile
+			}
+
+			count, err := strconv.ParseUint(fields[2], 10, 64)
+			if err != nil {
+				return nil, err
+			}
+
+			if fields[1] == "vertex" && r.vertex < 0 {
+				r.vertex = len(r.elements)
+			}
+			r.elements = append(r.elements, plyElement{name: fields[1], count: count})
+		case "property":
+			if len(r.elements) == 0 {
+				return nil, errInvalidFile
+			}
+
+			var prop plyProperty
+			if len(fields) == 5 && fields[1] == "list" {
+				prop = plyProperty{name: fields[4], typ: fields[3], countType: fields[2]}
+			} else if len(fields) == 3 {
+				prop = plyProperty{name: fields[2], typ: fields[1]}
+			} else {
+				return nil, errInvalidFile
+			}
+
+			if _, ok := plyTypeSizes[prop.typ]; ok == false {
+				return nil, errUnsupportedFormat
+			}
+
+			if _, ok := plyTypeSizes[prop.countType]; ok == false && prop.countType != "" {
+				return nil, errUnsupportedFormat
+			}
+
+			element := &r.elements[len(r.elements)-1]
+			element.properties = append(element.properties, prop)
+		case "end_header":
+			if r.ascii == false && r.order == nil {
+				return nil, errInvalidFile
+			}
+
+			if r.vertex < 0 {
+				return nil, errInvalidFile
+			}
+			return r, r.skipElements()
+		}
+	}
+}
+
+func (r *PLYReader) headerLine() (string, error) {
+	line, err := r.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+func (r *PLYReader) skipElements() error {
+	for _, element := range r.elements[:r.vertex] {
+		for i := uint64(0); i < element.count; i++ {
+			if err := r.readElement(&element, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// NumPoints returns the number of vertices in the file.
+func (r *PLYReader) NumPoints() uint64 {
+	return r.elements[r.vertex].count
+}
+
+func (r *PLYReader) value(typ string) (float64, error) {
+	if r.ascii == true {
+		if len(r.fields) == 0 {
+			return 0, errInvalidFile
+		}
+
+		field := r.fields[0]
+		r.fields = r.fields[1:]
+		return strconv.ParseFloat(field, 64)
+	}
+
+	data := r.buffer[:plyTypeSizes[typ]]
+	if _, err := io.ReadFull(r.reader, data); err != nil {
+		return 0, err
+	}
+
+	switch typ {
+	case "char", "int8":
+		return float64(int8(data[0])), nil
+	case "uchar", "uint8":
+		return float64(data[0]), nil
+	case "short", "int16":
+		return float64(int16(r.order.Uint16(data))), nil
+	case "ushort", "uint16":
+		return float64(r.order.Uint16(data)), nil
+	case "int", "int32":
+		return float64(int32(r.order.Uint32(data))), nil
+	case "uint", "uint32":
+		return float64(r.order.Uint32(data)), nil
+	case "float", "float32":
+		return float64(math.Float32frombits(r.order.Uint32(data))), nil
+	default:
+		return math.Float64frombits(r.order.Uint64(data)), nil
+	}
+}
+
+// readElement reads one element and calls fn with every scalar property.
+func (r *PLYReader) readElement(element *plyElement, fn func(prop *plyProperty, v float64)) error {
+	if r.ascii == true {
+		line, err := r.reader.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return err
+		}
+		r.fields = strings.Fields(line)
+	}
+
+	for i := range element.properties {
+		prop := &element.properties[i]
+		if prop.countType == "" {
+			v, err := r.value(prop.typ)
+			if err != nil {
+				return err
+			}
+
+			if fn != nil {
+				fn(prop, v)
+			}
+			continue
+		}
+
+		count, err := r.value(prop.countType)
+		if err != nil {
+			return err
+		}
+
+		for j := 0; j < int(count); j++ {
+			if _, err := r.value(prop.typ); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// plyColorScale returns the factor that maps a color property to [0, 1].
+func plyColorScale(typ string) float64 {
+	switch typ {
+	case "uchar", "uint8", "char", "int8":
+		return 1.0 / 255
+	case "ushort", "uint16", "short", "int16":
+		return 1.0 / 65535
+	default:
+		return 1
+	}
+}
+
+// Read reads the next vertex into s. It returns io.EOF after the last vertex.
+func (r *PLYReader) Read(s *Sample) error {
+	element := &r.elements[r.vertex]
+	if r.read >= element.count {
+		return io.EOF
+	}
+	r.read++
+
+	*s = Sample{Col: Color{1, 1, 1, 1}}
+	return r.readElement(element, func(prop *plyProperty, v float64) {
+		switch prop.name {
+		case "x":
+			s.Pos.X = v
+		case "y":
+			s.Pos.Y = v
+		case "z":
+			s.Pos.Z = v
+		case "red":
+			s.Col.R = float32(v * plyColorScale(prop.typ))
+		case "green":
+			s.Col.G = float32(v * plyColorScale(prop.typ))
+		case "blue":
+			s.Col.B = float32(v * plyColorScale(prop.typ))
+		case "alpha":
+			s.Col.A = float32(v * plyColorScale(prop.typ))
+		case "intensity":
+			s.Attributes.Intensity = float32(v)
+		case "nx":
+			s.Attributes.Normal[0] = float32(v)
+		case "ny":
+			s.Attributes.Normal[1] = float32(v)
+		case "nz":
+			s.Attributes.Normal[2] = float32(v)
+		}
+	})
+}
@@ -0,0 +1,142 @@
+/*
+Copyright (C) 2015-2016 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package pack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func readPLYSamples(data []byte) []Sample {
+	reader, err := NewPLYReader(bytes.NewReader(data))
+	if err != nil {
+		panic(err)
+	}
+
+	var samples []Sample
+	for {
+		var s Sample
+		if err := reader.Read(&s); err == io.EOF {
+			break
+		} else if err != nil {
+			panic(err)
+		}
+		samples = append(samples, s)
+	}
+
+	if uint64(len(samples)) != reader.NumPoints() {
+		panic("invalid number of points")
+	}
+	return samples
+}
+
+func TestPLYReaderASCII(t *testing.T) {
+	data := "ply\nformat ascii 1.0\ncomment test\n" +
+		"element material 2\nproperty list uchar int indices\n" +
+		"element vertex 2\nproperty float x\nproperty float y\nproperty float z\n" +
+		"property uchar red\nproperty uchar green\nproperty uchar blue\nproperty float intensity\n" +
+		"end_header\n" +
+		"3 0 1 2\n0\n" +
+		"1 2 3 255 0 51 0.5\n" +
+		"-4.5 5 6 0 255 0 1\n"
+
+	samples := readPLYSamples([]byte(data))
+	expected := []Sample{
+		{Pos: Point{1, 2, 3}, Col: Color{1, 0, 0.2, 1}},
+		{Pos: Point{-4.5, 5, 6}, Col: Color{0, 1, 0, 1}},
+	}
+	expected[0].Attributes.Intensity = 0.5
+	expected[1].Attributes.Intensity = 1
+
+	for i, s := range samples {
+		if s != expected[i] {
+			panic(fmt.Errorf("sample %v is %v, expected %v", i, s, expected[i]))
+		}
+	}
+}
+
+func TestPLYReaderBinary(t *testing.T) {
+	var data bytes.Buffer
+	data.WriteString("ply\nformat binary_big_endian 1.0\nelement vertex 1\n" +
+		"property double x\nproperty double y\nproperty double z\n" +
+		"property ushort red\nproperty ushort green\nproperty ushort blue\nproperty ushort alpha\n" +
+		"property list uchar uint faces\nproperty char nx\n" +
+		"element face 1\nproperty list uchar int vertex_indices\nend_header\n")
+
+	binary.Write(&data, binary.BigEndian, []float64{1, 2, 3})
+	binary.Write(&data, binary.BigEndian, []uint16{65535, 0, 65535, 0})
+	binary.Write(&data, binary.BigEndian, []uint8{2})
+	binary.Write(&data, binary.BigEndian, []uint32{7, 8})
+	binary.Write(&data, binary.BigEndian, []int8{-1})
+
+	samples := readPLYSamples(data.Bytes())
+	expected := Sample{Pos: Point{1, 2, 3}, Col: Color{1, 0, 1, 0}}
+	expected.Attributes.Normal[0] = -1
+
+	if len(samples) != 1 || samples[0] != expected {
+		panic(fmt.Errorf("read %v, expected %v", samples, expected))
+	}
+
+	// Exported trees read back the same in both encodings.
+	var built bytes.Buffer
+
+	cfg := testBuildConfig(MipR8G8B8A8UnpackUI32, &built)
+	if _, err := BuildTree(&cfg); err != nil {
+		panic(err)
+	}
+
+	var ascii, little bytes.Buffer
+	if _, err := ExportPoints(bytes.NewReader(built.Bytes()), &ascii, PointsPLYASCII, -1); err != nil {
+		panic(err)
+	}
+
+	if _, err := ExportPoints(bytes.NewReader(built.Bytes()), &little, PointsPLYBinary, -1); err != nil {
+		panic(err)
+	}
+
+	asciiSamples, littleSamples := readPLYSamples(ascii.Bytes()), readPLYSamples(little.Bytes())
+	if len(asciiSamples) != 7 || len(littleSamples) != 7 {
+		panic("invalid number of samples")
+	}
+
+	for i := range asciiSamples {
+		if asciiSamples[i] != littleSamples[i] {
+			panic(fmt.Errorf("sample %v differs, %v and %v", i, asciiSamples[i], littleSamples[i]))
+		}
+	}
+}
+
+func TestPLYReaderInvalid(t *testing.T) {
+	headers := []string{
+		"xyz\n",
+		"ply\nformat binary_middle_endian 1.0\nelement vertex 0\nend_header\n",
+		"ply\nformat ascii 1.0\nelement vertex 1\nproperty quad x\nend_header\n",
+		"ply\nformat ascii 1.0\nelement face 1\nend_header\n",
+		"ply\nformat ascii 1.0\nelement vertex 1\n",
+	}
+
+	for _, header := range headers {
+		if _, err := NewPLYReader(strings.NewReader(header)); err == nil {
+			panic(fmt.Errorf("accepted invalid header %q", header))
+		}
+	}
+}
@@ -0,0 +1,151 @@
+/*
+Copyright (C) 2015-2016 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package pack
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"os"
+)
+
+// OctreeReader provides random access to the nodes of an octree file.
+// The header is decoded once when the reader is created and every node
+// lookup is a single ReadAt call, so an OctreeReader can be shared by
+// any number of goroutines as long as the underlying io.ReaderAt can.
+//
+// Blocked trees are read one block at a time. Other compressed trees are
+// decompressed to a temporary file that is removed when the reader is closed.
+type OctreeReader struct {
+	reader io.ReaderAt
+	header OctreeHeader
+	temp   *os.File
+
+	codec   Codec
+	offsets []uint64
+	cache   *blockCache
+}
+
+func NewOctreeReader(reader io.ReaderAt) (*OctreeReader, error) {
+	r := &OctreeReader{reader: reader}
+	if err := DecodeHeader(sectionReader(reader), &r.header); err != nil {
+		return nil, err
+	}
+
+	if r.header.Blocked() == true {
+		codec, err := r.header.codec()
+		if err != nil {
+			return nil, err
+		}
+
+		offsets, err := readBlockTable(io.NewSectionReader(reader, int64(r.header.Size()), math.MaxInt64), &r.header)
+		if err != nil {
+			return nil, err
+		}
+
+		r.codec = codec
+		r.offsets = offsets
+		r.cache = newBlockCache()
+	} else if r.header.Compressed() == true {
+		fp, err := decompressToTempFile(sectionReader(reader))
+		if err != nil {
+			return nil, err
+		}
+
+		r.reader = fp
+		r.temp = fp
+		if err := DecodeHeader(fp, &r.header); err != nil {
+			r.Close()
+			return nil, err
+		}
+	}
+
+	if int(r.header.Format) >= len(formatIndexSize) {
+		return nil, errUnsupportedFormat
+	}
+
+	if r.header.Channels&^allChannels != 0 {
+		return nil, errUnknownChannels
+	}
+
+	return r, nil
+}
+
+// sectionReader returns a seekable reader for all of reader. The end of the
+// section is only known if reader reports its size.
+func sectionReader(reader io.ReaderAt) *io.SectionReader {
+	size := int64(math.MaxInt64)
+	switch r := reader.(type) {
+	case interface {
+		Size() int64
+	}:
+		size = r.Size()
+	case interface {
+		Stat() (os.FileInfo, error)
+	}:
+		if info, err := r.Stat(); err == nil {
+			size = info.Size()
+		}
+	}
+	return io.NewSectionReader(reader, 0, size)
+}
+
+func (r *OctreeReader) Close() error {
+	if r.temp != nil {
+		removeTempFile(r.temp)
+		r.temp = nil
+	}
+	return nil
+}
+
+func (r *OctreeReader) Header() OctreeHeader {
+	return r.header
+}
+
+func (r *OctreeReader) NumNodes() uint64 {
+	return r.header.NumNodes
+}
+
+func (r *OctreeReader) ReadNode(index uint64, color *Color, attr *Attributes, children []uint64) error {
+	if index >= r.header.NumNodes {
+		return errNodeOutOfRange
+	}
+
+	nodeSize := r.header.NodeSize()
+	if r.header.Blocked() == true {
+		data, err := r.readBlock(index / uint64(r.header.BlockSize))
+		if err != nil {
+			return err
+		}
+
+		start := int(index%uint64(r.header.BlockSize)) * nodeSize
+		if start+nodeSize > len(data) {
+			return errBlockTable
+		}
+		return DecodeNode(bytes.NewReader(data[start:start+nodeSize]), &r.header, index, color, attr, children)
+	}
+
+	offset := int64(r.header.Size()) + int64(index)*int64(nodeSize)
+
+	buffer := make([]byte, nodeSize)
+	if n, err := r.reader.ReadAt(buffer, offset); n < nodeSize {
+		return err
+	}
+
+	return DecodeNode(bytes.NewReader(buffer), &r.header, index, color, attr, children)
+}
@@ -0,0 +1,142 @@
+/*
+Copyright (C) 2015-2016 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package pack
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"testing"
+)
+
+var testFormats = [...]OctreeFormat{
+	MipR8G8B8A8UnpackUI32,
+	MipR8G8B8A8UnpackUI16,
+	MipR4G4B4A4UnpackUI16,
+	MipR5G6B5UnpackUI16,
+
+	MipR8G8B8A8PackUI28,
+	MipR4G4B4A4PackUI30,
+	MipR5G6B5PackUI30,
+	MipR3G3B2PackUI31,
+
+	MipR8G8B8MaskUI32,
+	MipP8PackUI31,
+
+	MipR8G8B8A8UnpackUI64,
+
+	MipR8G8B8A8PackRelUI28,
+	MipR4G4B4A4PackRelUI30,
+	MipR5G6B5PackRelUI30,
+	MipR3G3B2PackRelUI31,
+}
+
+func testSampleWorker(samples chan<- Sample) error {
+	infile, err := os.Open("test.xyz")
+	if err != nil {
+		return err
+	}
+	defer infile.Close()
+
+	var (
+		s       Sample
+		r, g, b byte
+		ref     float32
+	)
+
+	scanner := bufio.NewScanner(infile)
+	for scanner.Scan() {
+		if _, err := fmt.Sscan(scanner.Text(), &s.Pos.X, &s.Pos.Y, &s.Pos.Z, &ref, &r, &g, &b); err != nil {
+			return err
+		}
+
+		s.Col = Color{float32(r) / 255, float32(g) / 255, float32(b) / 255, 1}
+		samples <- s
+	}
+	return scanner.Err()
+}
+
+func testBuildConfig(format OctreeFormat, writer io.Writer) BuildConfig {
+	return BuildConfig{
+		Worker:         testSampleWorker,
+		Writer:         writer,
+		Bounds:         Box{Point{0, 0, 0}, 80},
+		VoxelsPerAxis:  8,
+		Format:         format,
+		Optimize:       true,
+		ColorFilter:    true,
+		ColorThreshold: 0.25,
+	}
+}
+
+func buildTestTree(format OctreeFormat) []byte {
+	var buffer bytes.Buffer
+	cfg := testBuildConfig(format, &buffer)
+	if _, err := BuildTree(&cfg); err != nil {
+		panic(err)
+	}
+	return buffer.Bytes()
+}
+
+func TestOctreeReader(t *testing.T) {
+	for _, format := range testFormats {
+		data := buildTestTree(format)
+
+		header, colors, children := decodeTestTree(data)
+		reader, err := NewOctreeReader(bytes.NewReader(data))
+		if err != nil {
+			panic(err)
+		}
+
+		if reader.NumNodes() != header.NumNodes {
+			panic("reader.NumNodes() != header.NumNodes")
+		}
+
+		var wg sync.WaitGroup
+		for n := 0; n < 4; n++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				var (
+					color Color
+					ch    [8]uint64
+				)
+
+				for i := len(colors) - 1; i >= 0; i-- {
+					if err := reader.ReadNode(uint64(i), &color, nil, ch[:]); err != nil {
+						panic(err)
+					}
+
+					if color != colors[i] || ch != children[i] {
+						panic(fmt.Errorf("node %v mismatch in format %v", i, format))
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		var color Color
+		if err := reader.ReadNode(header.NumNodes, &color, nil, nil); err != errNodeOutOfRange {
+			panic("expected errNodeOutOfRange")
+		}
+	}
+}
@@ -0,0 +1,180 @@
+/*
+Copyright (C) 2015-2016 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package pack
+
+import (
+	"encoding/binary"
+	"io"
+	"sort"
+)
+
+type relativeFormat struct {
+	base OctreeFormat
+	bits uint
+}
+
+var relativeFormats = map[OctreeFormat]relativeFormat{
+	MipR8G8B8A8PackRelUI28: {MipR8G8B8A8PackUI28, 28},
+	MipR4G4B4A4PackRelUI30: {MipR4G4B4A4PackUI30, 30},
+	MipR5G6B5PackRelUI30:   {MipR5G6B5PackUI30, 30},
+	MipR3G3B2PackRelUI31:   {MipR3G3B2PackUI31, 31},
+}
+
+// Relative reports whether children are stored as an offset from the index
+// of their parent. Children that are out of reach are stored as an index in
+// the far pointer table of the header.
+func (f OctreeFormat) Relative() bool {
+	_, ok := relativeFormats[f]
+	return ok
+}
+
+// farFlag returns the bit that marks a far pointer in a relative format.
+func (f OctreeFormat) farFlag() uint64 {
+	return 1 << (relativeFormats[f].bits - 1)
+}
+
+func (h *OctreeHeader) encodeRelative(index uint64, children, relative []uint64) error {
+	far := h.Format.farFlag()
+	for i, child := range children {
+		if child == 0 {
+			relative[i] = 0
+			continue
+		}
+
+		if child > index && child-index < far {
+			relative[i] = child - index
+			continue
+		}
+
+		table := h.FarPointers
+		slot := sort.Search(len(table), func(j int) bool { return table[j] >= child })
+		if slot == len(table) || table[slot] != child {
+			return errFarPointer
+		}
+
+		if uint64(slot) >= far {
+			return errOctreeOverflow
+		}
+		relative[i] = far | uint64(slot)
+	}
+	return nil
+}
+
+func (h *OctreeHeader) decodeRelative(index uint64, children []uint64) error {
+	far := h.Format.farFlag()
+	for i, child := range children {
+		if child == 0 {
+			continue
+		}
+
+		if child&far == 0 {
+			children[i] = index + child
+			continue
+		}
+
+		slot := child &^ far
+		if slot >= uint64(len(h.FarPointers)) {
+			return errFarPointer
+		}
+		children[i] = h.FarPointers[slot]
+	}
+	return nil
+}
+
+func (h *OctreeHeader) farTableSize() int {
+	if h.Format.Relative() == false {
+		return 0
+	}
+	return 8 + 8*len(h.FarPointers)
+}
+
+func decodeFarTable(reader io.Reader, header *OctreeHeader) error {
+	var size uint64
+	if err := binary.Read(reader, header.ByteOrder(), &size); err != nil {
+		return err
+	}
+
+	// Every child of every node could be a far pointer, but no more.
+	if size > header.NumNodes*8 {
+		return errFarPointer
+	}
+
+	header.FarPointers = make([]uint64, size)
+	return binary.Read(reader, header.ByteOrder(), header.FarPointers)
+}
+
+func encodeFarTable(writer io.Writer, header *OctreeHeader) error {
+	if err := binary.Write(writer, header.ByteOrder(), uint64(len(header.FarPointers))); err != nil {
+		return err
+	}
+	return binary.Write(writer, header.ByteOrder(), header.FarPointers)
+}
+
+// farTable collects the children that a relative format can not reach from
+// their parent.
+type farTable map[uint64]struct{}
+
+func (t farTable) add(format OctreeFormat, index uint64, children []uint64) {
+	far := format.farFlag()
+	for _, child := range children {
+		if child > 0 && (child <= index || child-index >= far) {
+			t[child] = struct{}{}
+		}
+	}
+}
+
+func (t farTable) pointers() []uint64 {
+	pointers := make(uint64Slice, 0, len(t))
+	for child := range t {
+		pointers = append(pointers, child)
+	}
+	sort.Sort(pointers)
+	return pointers
+}
+
+// treeFarPointers returns the far pointer table needed to store tree in
+// format.
+func treeFarPointers(tree *OctreeReader, format OctreeFormat) ([]uint64, error) {
+	var (
+		color    Color
+		children [8]uint64
+	)
+
+	table := make(farTable)
+	for i := uint64(0); i < tree.NumNodes(); i++ {
+		if err := tree.ReadNode(i, &color, nil, children[:]); err != nil {
+			return nil, err
+		}
+		table.add(format, i, children[:])
+	}
+	return table.pointers(), nil
+}
+
+type uint64Slice []uint64
+
+func (s uint64Slice) Len() int {
+	return len(s)
+}
+
+func (s uint64Slice) Less(i, j int) bool {
+	return s[i] < s[j]
+}
+
+func (s uint64Slice) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
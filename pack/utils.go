@@ -58,23 +58,23 @@ func (color *Color) dist(c *Color) float32 {
 	return float32(math.Sqrt(math.Pow(float64(c.R-color.R), 2) + math.Pow(float64(c.G-color.G), 2) + math.Pow(float64(c.B-color.B), 2) + math.Pow(float64(c.A-color.A), 2)))
 }
 
-func (color *Color) writeColor(writer io.Writer, format OctreeFormat) error {
+func (color *Color) writeColor(writer io.Writer, order binary.ByteOrder, format OctreeFormat) error {
 	c := *color
 
 	switch format {
-	case MipR8G8B8A8UnpackUI32:
+	case MipR8G8B8A8UnpackUI32, MipR8G8B8A8UnpackUI64:
 		c.scale(255)
-		err := binary.Write(writer, binary.LittleEndian, byte(c.R))
-		err = binary.Write(writer, binary.LittleEndian, byte(c.G))
-		err = binary.Write(writer, binary.LittleEndian, byte(c.B))
-		err = binary.Write(writer, binary.LittleEndian, byte(c.A))
+		err := binary.Write(writer, order, byte(c.R))
+		err = binary.Write(writer, order, byte(c.G))
+		err = binary.Write(writer, order, byte(c.B))
+		err = binary.Write(writer, order, byte(c.A))
 		return err
 	case MipR8G8B8A8UnpackUI16:
 		c.scale(255)
-		err := binary.Write(writer, binary.LittleEndian, byte(c.R))
-		err = binary.Write(writer, binary.LittleEndian, byte(c.G))
-		err = binary.Write(writer, binary.LittleEndian, byte(c.B))
-		err = binary.Write(writer, binary.LittleEndian, byte(c.A))
+		err := binary.Write(writer, order, byte(c.R))
+		err = binary.Write(writer, order, byte(c.G))
+		err = binary.Write(writer, order, byte(c.B))
+		err = binary.Write(writer, order, byte(c.A))
 		return err
 	case MipR4G4B4A4UnpackUI16:
 		c.scale(15)
@@ -82,13 +82,13 @@ func (color *Color) writeColor(writer io.Writer, format OctreeFormat) error {
 		g := uint16(c.G) & 0xf
 		b := uint16(c.B) & 0xf
 		a := uint16(c.A) & 0xf
-		err := binary.Write(writer, binary.LittleEndian, r<<12|g<<8|b<<4|a)
+		err := binary.Write(writer, order, r<<12|g<<8|b<<4|a)
 		return err
 	case MipR5G6B5UnpackUI16:
 		r := uint16(c.R*31) & 0x1f
 		g := uint16(c.G*63) & 0x3f
 		b := uint16(c.B*31) & 0x1f
-		err := binary.Write(writer, binary.LittleEndian, r<<11|g<<5|b)
+		err := binary.Write(writer, order, r<<11|g<<5|b)
 		return err
 	default:
 		return errUnsupportedFormat
@@ -107,6 +107,61 @@ func (point *Point) add(p *Point) Point {
 	return Point{point.X + p.X, point.Y + p.Y, point.Z + p.Z}
 }
 
+// transform applies the column-major matrix m, as stored in
+// OctreeHeader.Transform, to the point.
+func (point *Point) transform(m *[16]float64) Point {
+	x, y, z := point.X, point.Y, point.Z
+	return Point{
+		m[0]*x + m[4]*y + m[8]*z + m[12],
+		m[1]*x + m[5]*y + m[9]*z + m[13],
+		m[2]*x + m[6]*y + m[10]*z + m[14],
+	}
+}
+
+// transformDeterminant returns the determinant of the upper 3x3 part of the
+// column-major matrix m, it is negative if m mirrors the space.
+func transformDeterminant(m *[16]float64) float64 {
+	return m[0]*(m[5]*m[10]-m[9]*m[6]) - m[4]*(m[1]*m[10]-m[9]*m[2]) + m[8]*(m[1]*m[6]-m[5]*m[2])
+}
+
+// inverseTransform inverts an affine column-major matrix. A zero matrix, as
+// in trees built without a transform, is treated as the identity.
+func inverseTransform(m [16]float64) ([16]float64, error) {
+	identity := [16]float64{1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1}
+	if m == ([16]float64{}) {
+		return identity, nil
+	}
+
+	if m[3] != 0 || m[7] != 0 || m[11] != 0 || m[15] != 1 {
+		return m, errInvertTransform
+	}
+
+	// Inverse of the upper 3x3 part from its cofactors.
+	a := func(row, col int) float64 { return m[col*4+row] }
+	cofactor := func(row, col int) float64 {
+		r0, r1 := (row+1)%3, (row+2)%3
+		c0, c1 := (col+1)%3, (col+2)%3
+		return a(r0, c0)*a(r1, c1) - a(r0, c1)*a(r1, c0)
+	}
+
+	det := a(0, 0)*cofactor(0, 0) + a(0, 1)*cofactor(0, 1) + a(0, 2)*cofactor(0, 2)
+	if det == 0 {
+		return m, errInvertTransform
+	}
+
+	inv := identity
+	for row := 0; row < 3; row++ {
+		for col := 0; col < 3; col++ {
+			inv[col*4+row] = cofactor(col, row) / det
+		}
+	}
+
+	for row := 0; row < 3; row++ {
+		inv[12+row] = -(inv[row]*m[12] + inv[4+row]*m[13] + inv[8+row]*m[14])
+	}
+	return inv, nil
+}
+
 type Box struct {
 	Pos  Point
 	Size float64
@@ -0,0 +1,222 @@
+/*
+Copyright (C) 2015-2016 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package pack
+
+import (
+	"io"
+)
+
+// ValidationReport describes the problems found by Validate. Node problems
+// are reported as lists of node indices.
+type ValidationReport struct {
+	Header   OctreeHeader
+	NumLeafs uint64
+
+	// Errors holds problems with the file as a whole, such as a bad
+	// signature or a leaf count that does not match the header.
+	Errors []error
+
+	// OutOfRange lists nodes with child indices outside the tree.
+	OutOfRange []uint64
+
+	// Cycles lists nodes with a child that is also one of their ancestors.
+	Cycles []uint64
+
+	// Shared lists nodes that are the child of more than one parent. Shared
+	// nodes are only reported for trees that are not a DAG.
+	Shared []uint64
+
+	// Unreachable lists nodes that can not be reached from the root.
+	Unreachable []uint64
+}
+
+func (r *ValidationReport) Valid() bool {
+	return len(r.Errors) == 0 && len(r.OutOfRange) == 0 && len(r.Cycles) == 0 && len(r.Shared) == 0 && len(r.Unreachable) == 0
+}
+
+type nodeSet []uint64
+
+func newNodeSet(size uint64) nodeSet {
+	return make(nodeSet, (size+63)/64)
+}
+
+func (s nodeSet) get(i uint64) bool {
+	return s[i/64]&(1<<(i%64)) != 0
+}
+
+func (s nodeSet) set(i uint64, v bool) {
+	if v == true {
+		s[i/64] |= 1 << (i % 64)
+	} else {
+		s[i/64] &^= 1 << (i % 64)
+	}
+}
+
+// Validate checks that the tree read from reader is well formed. The returned
+// error is only set when the tree could not be read, all problems with the
+// tree itself are listed in the report.
+func Validate(reader io.ReaderAt) (ValidationReport, error) {
+	var report ValidationReport
+
+	header := &report.Header
+	if err := DecodeHeader(sectionReader(reader), header); err != nil {
+		if err == errInvalidSignature || err == errUnsupportedVersion || err == errFarPointer {
+			report.Errors = append(report.Errors, err)
+			return report, nil
+		}
+		return report, err
+	}
+
+	// The builder format is only used for temporary files.
+	if int(header.Format) >= len(formatIndexSize) || header.Format == mipR64G64B64A64S64UnpackUI64 {
+		report.Errors = append(report.Errors, errUnsupportedFormat)
+		return report, nil
+	}
+
+	if header.Flags&^(endianMask|compressedMask|optimizedMask|filteredMask|blockedMask|dagMask) != 0 {
+		report.Errors = append(report.Errors, errUnknownFlags)
+	}
+
+	if header.Channels&^allChannels != 0 {
+		report.Errors = append(report.Errors, errUnknownChannels)
+		return report, nil
+	}
+
+	if header.Filtered() == true && header.Optimized() == false {
+		report.Errors = append(report.Errors, errFilterFlag)
+	}
+
+	if header.Blocked() == true {
+		if header.Compressed() == false {
+			report.Errors = append(report.Errors, errBlockedFlag)
+			return report, nil
+		}
+
+		if header.BlockSize == 0 {
+			report.Errors = append(report.Errors, errBlockSize)
+			return report, nil
+		}
+	}
+
+	if _, err := header.codec(); err != nil {
+		report.Errors = append(report.Errors, err)
+		return report, nil
+	}
+
+	if header.NumNodes == 0 {
+		report.Errors = append(report.Errors, errEmptyTree)
+		return report, nil
+	}
+
+	tree, err := NewOctreeReader(reader)
+	if err != nil {
+		return report, err
+	}
+	defer tree.Close()
+
+	type frame struct {
+		index    uint64
+		next     int
+		children [8]uint64
+	}
+
+	var (
+		color  Color
+		stack  []frame
+		onPath = newNodeSet(header.NumNodes)
+		seen   = newNodeSet(header.NumNodes)
+		shared = newNodeSet(header.NumNodes)
+	)
+
+	enter := func(index uint64) error {
+		var f frame
+		f.index = index
+
+		// A far pointer outside the table leaves the node without children.
+		isLeaf := true
+		if err := tree.ReadNode(index, &color, nil, f.children[:]); err == errFarPointer {
+			report.OutOfRange = append(report.OutOfRange, index)
+			f.children = [8]uint64{}
+			isLeaf = false
+		} else if err != nil {
+			return err
+		}
+
+		for _, child := range f.children {
+			if child > 0 {
+				isLeaf = false
+				if child >= header.NumNodes {
+					report.OutOfRange = append(report.OutOfRange, index)
+					break
+				}
+			}
+		}
+
+		if isLeaf == true {
+			report.NumLeafs++
+		}
+
+		seen.set(index, true)
+		onPath.set(index, true)
+		stack = append(stack, f)
+		return nil
+	}
+
+	if err := enter(0); err != nil {
+		return report, err
+	}
+
+	for len(stack) > 0 {
+		top := &stack[len(stack)-1]
+		if top.next == len(top.children) {
+			onPath.set(top.index, false)
+			stack = stack[:len(stack)-1]
+			continue
+		}
+
+		child := top.children[top.next]
+		top.next++
+
+		if child == 0 || child >= header.NumNodes {
+			continue
+		}
+
+		if onPath.get(child) == true {
+			report.Cycles = append(report.Cycles, top.index)
+		} else if seen.get(child) == true {
+			if header.DAG() == false && shared.get(child) == false {
+				shared.set(child, true)
+				report.Shared = append(report.Shared, child)
+			}
+		} else if err := enter(child); err != nil {
+			return report, err
+		}
+	}
+
+	for i := uint64(0); i < header.NumNodes; i++ {
+		if seen.get(i) == false {
+			report.Unreachable = append(report.Unreachable, i)
+		}
+	}
+
+	if report.NumLeafs != header.NumLeafs {
+		report.Errors = append(report.Errors, errNumLeafs)
+	}
+
+	return report, nil
+}
@@ -0,0 +1,151 @@
+/*
+Copyright (C) 2015-2016 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package pack
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestValidateBuiltTrees(t *testing.T) {
+	for _, optimize := range []bool{false, true} {
+		for _, format := range testFormats {
+			var buffer bytes.Buffer
+			cfg := testBuildConfig(format, &buffer)
+			cfg.Optimize = optimize
+
+			if _, err := BuildTree(&cfg); err != nil {
+				panic(err)
+			}
+
+			report, err := Validate(bytes.NewReader(buffer.Bytes()))
+			if err != nil {
+				panic(err)
+			}
+
+			if report.Valid() == false {
+				panic(fmt.Errorf("format %v, optimize %v: %+v", format, optimize, report))
+			}
+		}
+	}
+}
+
+func encodeTestNodes(header OctreeHeader, nodes [][8]uint64) []byte {
+	var buffer bytes.Buffer
+	header.NumNodes = uint64(len(nodes))
+	if err := EncodeHeader(&buffer, header); err != nil {
+		panic(err)
+	}
+
+	for i, children := range nodes {
+		if err := EncodeNode(&buffer, &header, uint64(i), Color{}, nil, children[:]); err != nil {
+			panic(err)
+		}
+	}
+	return buffer.Bytes()
+}
+
+func TestValidateCorruptTree(t *testing.T) {
+	header := OctreeHeader{Sign: octreeSignature, Version: binaryVersion, Format: MipR8G8B8A8UnpackUI32, NumLeafs: 1}
+	nodes := [][8]uint64{
+		{1, 2, 3},
+		{0, 0, 0, 0, 0, 0, 0, 1},
+		{3, 42},
+		{},
+		{5},
+		{4},
+	}
+
+	report, err := Validate(bytes.NewReader(encodeTestNodes(header, nodes)))
+	if err != nil {
+		panic(err)
+	}
+
+	expect := ValidationReport{
+		Header:      report.Header,
+		NumLeafs:    1,
+		OutOfRange:  []uint64{2},
+		Cycles:      []uint64{1},
+		Shared:      []uint64{3},
+		Unreachable: []uint64{4, 5},
+	}
+
+	if fmt.Sprint(report) != fmt.Sprint(expect) {
+		panic(fmt.Errorf("%+v != %+v", report, expect))
+	}
+
+	header.Sign[0] = 0
+	report, err = Validate(bytes.NewReader(encodeTestNodes(header, nodes)))
+	if err != nil {
+		panic(err)
+	}
+
+	if len(report.Errors) != 1 || report.Errors[0] != errInvalidSignature {
+		panic("expected errInvalidSignature")
+	}
+}
+
+func TestValidateFarPointers(t *testing.T) {
+	var buffer bytes.Buffer
+	header := OctreeHeader{Sign: octreeSignature, Version: binaryVersion, Format: MipR8G8B8A8PackRelUI28, NumNodes: 3, NumLeafs: 1}
+	if err := EncodeHeader(&buffer, header); err != nil {
+		panic(err)
+	}
+
+	// Node 2 points back to node 1 through a far table the header lacks.
+	nodeHeader := header
+	nodeHeader.FarPointers = []uint64{1}
+	for i, children := range [][8]uint64{{1, 2}, {}, {1}} {
+		if err := EncodeNode(&buffer, &nodeHeader, uint64(i), Color{}, nil, children[:]); err != nil {
+			panic(err)
+		}
+	}
+
+	report, err := Validate(bytes.NewReader(buffer.Bytes()))
+	if err != nil {
+		panic(err)
+	}
+
+	expect := ValidationReport{
+		Header:     report.Header,
+		NumLeafs:   1,
+		OutOfRange: []uint64{2},
+	}
+
+	if fmt.Sprint(report) != fmt.Sprint(expect) {
+		panic(fmt.Errorf("%+v != %+v", report, expect))
+	}
+}
+
+func TestValidateBuilderFormat(t *testing.T) {
+	var buffer bytes.Buffer
+	header := OctreeHeader{Sign: octreeSignature, Version: binaryVersion, Format: mipR64G64B64A64S64UnpackUI64, NumNodes: 1, NumLeafs: 1}
+	if err := EncodeHeader(&buffer, header); err != nil {
+		panic(err)
+	}
+
+	report, err := Validate(bytes.NewReader(buffer.Bytes()))
+	if err != nil {
+		panic(err)
+	}
+
+	if len(report.Errors) != 1 || report.Errors[0] != errUnsupportedFormat {
+		panic(fmt.Errorf("expected errUnsupportedFormat: %+v", report))
+	}
+}
@@ -0,0 +1,308 @@
+/*
+Copyright (C) 2015-2016 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package pack
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+)
+
+const (
+	voxVersion   = 150
+	voxMaxSize   = 256
+	voxChunkSize = 12
+)
+
+var (
+	voxSignature = [4]byte{'V', 'O', 'X', ' '}
+	voxMain      = [4]byte{'M', 'A', 'I', 'N'}
+	voxSize      = [4]byte{'S', 'I', 'Z', 'E'}
+	voxXYZI      = [4]byte{'X', 'Y', 'Z', 'I'}
+	voxRGBA      = [4]byte{'R', 'G', 'B', 'A'}
+)
+
+// VoxModel is a model read from a MagicaVoxel .vox file. Voxels hold
+// X, Y, Z and a color index into Palette.
+type VoxModel struct {
+	Size    [3]int
+	Voxels  [][4]byte
+	Palette Palette
+}
+
+type voxChunk struct {
+	ID           [4]byte
+	Content      uint32
+	ChildContent uint32
+}
+
+// voxDefaultPalette is the palette MagicaVoxel uses for files without a RGBA
+// chunk. Index zero is empty, followed by a 6x6x6 color cube without black
+// and ramps of red, green, blue and gray.
+func voxDefaultPalette() Palette {
+	var (
+		pal   Palette
+		steps = [...]byte{0xff, 0xcc, 0x99, 0x66, 0x33, 0x00}
+		ramp  = [...]byte{0xee, 0xdd, 0xbb, 0xaa, 0x88, 0x77, 0x55, 0x44, 0x22, 0x11}
+	)
+
+	i := 1
+	for _, r := range steps {
+		for _, g := range steps {
+			for _, b := range steps {
+				if r|g|b != 0 {
+					pal[i] = [4]byte{r, g, b, 0xff}
+					i++
+				}
+			}
+		}
+	}
+
+	for ch := 0; ch < 4; ch++ {
+		for _, v := range ramp {
+			c := [4]byte{0, 0, 0, 0xff}
+			if ch == 3 {
+				c = [4]byte{v, v, v, 0xff}
+			} else {
+				c[ch] = v
+			}
+			pal[i] = c
+			i++
+		}
+	}
+	return pal
+}
+
+// ReadVox reads the first model of a MagicaVoxel .vox file. Scene graph
+// and material chunks are skipped.
+func ReadVox(reader io.Reader) (*VoxModel, error) {
+	var (
+		sign    [4]byte
+		version uint32
+		main    voxChunk
+	)
+
+	order := binary.LittleEndian
+	if err := binary.Read(reader, order, &sign); err != nil {
+		return nil, err
+	}
+
+	if sign != voxSignature {
+		return nil, errInvalidSignature
+	}
+
+	if err := binary.Read(reader, order, &version); err != nil {
+		return nil, err
+	}
+
+	if err := binary.Read(reader, order, &main); err != nil {
+		return nil, err
+	}
+
+	if main.ID != voxMain {
+		return nil, errInvalidFile
+	}
+
+	if _, err := io.CopyN(ioutil.Discard, reader, int64(main.Content)); err != nil {
+		return nil, err
+	}
+
+	model := &VoxModel{Palette: voxDefaultPalette()}
+	haveSize, haveVoxels := false, false
+
+	for remaining := int64(main.ChildContent); remaining > 0; {
+		var chunk voxChunk
+		if err := binary.Read(reader, order, &chunk); err != nil {
+			return nil, err
+		}
+
+		remaining -= voxChunkSize + int64(chunk.Content) + int64(chunk.ChildContent)
+		content := io.LimitReader(reader, int64(chunk.Content))
+
+		switch {
+		case chunk.ID == voxSize && haveSize == false:
+			var size [3]uint32
+			if err := binary.Read(content, order, &size); err != nil {
+				return nil, err
+			}
+
+			for i, s := range size {
+				if s == 0 || s > voxMaxSize {
+					return nil, errInvalidFile
+				}
+				model.Size[i] = int(s)
+			}
+			haveSize = true
+		case chunk.ID == voxXYZI && haveVoxels == false:
+			var n uint32
+			if err := binary.Read(content, order, &n); err != nil {
+				return nil, err
+			}
+
+			if n > uint32(chunk.Content/4) {
+				return nil, errInvalidFile
+			}
+
+			model.Voxels = make([][4]byte, n)
+			if err := binary.Read(content, order, model.Voxels); err != nil {
+				return nil, err
+			}
+			haveVoxels = true
+		case chunk.ID == voxRGBA:
+			var rgba [256][4]byte
+			if err := binary.Read(content, order, &rgba); err != nil {
+				return nil, err
+			}
+
+			// Color index i is stored at i-1.
+			copy(model.Palette[1:], rgba[:255])
+		}
+
+		if _, err := io.Copy(ioutil.Discard, content); err != nil {
+			return nil, err
+		}
+
+		if _, err := io.CopyN(ioutil.Discard, reader, int64(chunk.ChildContent)); err != nil {
+			return nil, err
+		}
+	}
+
+	if haveSize == false || haveVoxels == false {
+		return nil, errInvalidFile
+	}
+
+	for _, v := range model.Voxels {
+		if int(v[0]) >= model.Size[0] || int(v[1]) >= model.Size[1] || int(v[2]) >= model.Size[2] {
+			return nil, errInvalidFile
+		}
+	}
+	return model, nil
+}
+
+// VoxelsPerAxis returns the smallest power of two that fits the model.
+func (m *VoxModel) VoxelsPerAxis() int {
+	vpa := 1
+	for vpa < m.Size[0] || vpa < m.Size[1] || vpa < m.Size[2] {
+		vpa *= 2
+	}
+	return vpa
+}
+
+// Bounds returns bounds for building a tree with one unit per voxel.
+func (m *VoxModel) Bounds() Box {
+	return Box{Point{0, 0, 0}, float64(m.VoxelsPerAxis())}
+}
+
+// Worker returns a BuildWorker with a sample in the center of every voxel.
+// MagicaVoxel is Z-up, the model is rotated to have Y up. The Y axis is
+// flipped within VoxelsPerAxis, as ExportVox does, so a model keeps its
+// position through an import and export.
+func (m *VoxModel) Worker() BuildWorker {
+	vpa := m.VoxelsPerAxis()
+	return func(samples chan<- Sample) error {
+		for _, v := range m.Voxels {
+			var s Sample
+			s.Pos = Point{float64(v[0]) + 0.5, float64(v[2]) + 0.5, float64(vpa-1-int(v[1])) + 0.5}
+			s.Col = m.Palette.Color(v[3])
+			s.Col.A = 1
+			samples <- s
+		}
+		return nil
+	}
+}
+
+// ExportVox writes the voxels at depth of the tree in reader as a MagicaVoxel
+// .vox model, with a palette quantized from their colors. The level can hold
+// at most 256 voxels per axis, a negative depth uses the deepest level.
+//
+// Unlike ExportPoints and ExportMesh the header transform is not inverted, a
+// rotated voxel would not fit the vox grid. The model is in tree coordinates,
+// one vox voxel per tree voxel.
+func ExportVox(reader io.ReaderAt, writer io.Writer, depth int) error {
+	tree, err := NewOctreeReader(reader)
+	if err != nil {
+		return err
+	}
+	defer tree.Close()
+
+	header := tree.Header()
+	if header.Bounds.Size <= 0 || header.VoxelsPerAxis == 0 {
+		return errMissingBounds
+	}
+
+	maxDepth := voxelDepth(header.VoxelsPerAxis)
+	if depth < 0 || depth > maxDepth {
+		depth = maxDepth
+	}
+
+	size := int64(1) << uint(depth)
+	if size > voxMaxSize {
+		return errVoxelsPerAxis
+	}
+
+	// MagicaVoxel keeps the first color that is set in a voxel.
+	var (
+		voxels  [][4]byte
+		colors  []Color
+		builder = newPaletteBuilder()
+		seen    = make(map[gridCell]struct{})
+	)
+
+	err = walkCells(tree, depth, func(cell gridCell, color Color) error {
+		if _, ok := seen[cell]; ok == true {
+			return nil
+		}
+		seen[cell] = struct{}{}
+
+		color.A = 1
+		builder.add(color)
+		voxels = append(voxels, [4]byte{byte(cell[0]), byte(size - 1 - cell[2]), byte(cell[1])})
+		colors = append(colors, color)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	pal := builder.paletteSize(255)
+	for i := range voxels {
+		voxels[i][3] = pal.nearest(colors[i], 255) + 1
+	}
+
+	buffer := bufio.NewWriter(writer)
+	order := binary.LittleEndian
+
+	xyziSize := uint32(4 + 4*len(voxels))
+	children := 3*voxChunkSize + 12 + xyziSize + 1024
+
+	fields := []interface{}{
+		voxSignature, uint32(voxVersion),
+		voxChunk{voxMain, 0, children},
+		voxChunk{voxSize, 12, 0}, [3]uint32{uint32(size), uint32(size), uint32(size)},
+		voxChunk{voxXYZI, xyziSize, 0}, uint32(len(voxels)), voxels,
+		voxChunk{voxRGBA, 1024, 0}, pal,
+	}
+
+	for _, field := range fields {
+		if err := binary.Write(buffer, order, field); err != nil {
+			return err
+		}
+	}
+	return buffer.Flush()
+}
@@ -0,0 +1,196 @@
+/*
+Copyright (C) 2015-2016 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package pack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+func TestVoxRoundTrip(t *testing.T) {
+	var built, vox, rebuilt, vox2 bytes.Buffer
+
+	cfg := testBuildConfig(MipR8G8B8A8UnpackUI32, &built)
+	cfg.Optimize = false
+	if _, err := BuildTree(&cfg); err != nil {
+		panic(err)
+	}
+
+	if err := ExportVox(bytes.NewReader(built.Bytes()), &vox, -1); err != nil {
+		panic(err)
+	}
+
+	model, err := ReadVox(bytes.NewReader(vox.Bytes()))
+	if err != nil {
+		panic(err)
+	}
+
+	if model.Size != [3]int{8, 8, 8} || len(model.Voxels) != 7 || model.Bounds() != (Box{Point{0, 0, 0}, 8}) {
+		panic(fmt.Errorf("unexpected model: %v %v", model.Size, model.Voxels))
+	}
+
+	voxCfg := testBuildConfig(MipR8G8B8A8UnpackUI32, &rebuilt)
+	voxCfg.Worker = model.Worker()
+	voxCfg.Bounds = model.Bounds()
+	voxCfg.VoxelsPerAxis = model.VoxelsPerAxis()
+	voxCfg.Optimize = false
+	if _, err := BuildTree(&voxCfg); err != nil {
+		panic(err)
+	}
+
+	// The nodes are stored in another order, the root and leafs must match.
+	header, colors, _ := decodeTestTree(built.Bytes())
+	h, c, _ := decodeTestTree(rebuilt.Bytes())
+	if c[0] != colors[0] || h.NumNodes != header.NumNodes || h.NumLeafs != header.NumLeafs {
+		panic("tree changed by vox round-trip")
+	}
+
+	if err := ExportVox(bytes.NewReader(rebuilt.Bytes()), &vox2, -1); err != nil {
+		panic(err)
+	}
+
+	if bytes.Equal(vox.Bytes(), vox2.Bytes()) == false {
+		panic("vox export is not stable")
+	}
+
+	var small bytes.Buffer
+	if err := ExportVox(bytes.NewReader(built.Bytes()), &small, 1); err != nil {
+		panic(err)
+	}
+
+	if model, err = ReadVox(bytes.NewReader(small.Bytes())); err != nil || model.Size != [3]int{2, 2, 2} || len(model.Voxels) != 2 {
+		panic(fmt.Errorf("unexpected model at depth 1: %v", err))
+	}
+}
+
+func TestVoxRoundTripBox(t *testing.T) {
+	model := &VoxModel{Size: [3]int{3, 5, 2}, Palette: voxDefaultPalette()}
+	for x := 0; x < 3; x++ {
+		for y := 0; y < 5; y++ {
+			for z := 0; z < 2; z++ {
+				if (x+y+z)%2 == 0 {
+					model.Voxels = append(model.Voxels, [4]byte{byte(x), byte(y), byte(z), byte(1 + x + 3*y + 15*z)})
+				}
+			}
+		}
+	}
+
+	var built, vox bytes.Buffer
+
+	cfg := testBuildConfig(MipR8G8B8A8UnpackUI32, &built)
+	cfg.Worker = model.Worker()
+	cfg.Bounds = model.Bounds()
+	cfg.VoxelsPerAxis = model.VoxelsPerAxis()
+	cfg.Optimize = false
+	if _, err := BuildTree(&cfg); err != nil {
+		panic(err)
+	}
+
+	if err := ExportVox(bytes.NewReader(built.Bytes()), &vox, -1); err != nil {
+		panic(err)
+	}
+
+	exported, err := ReadVox(bytes.NewReader(vox.Bytes()))
+	if err != nil {
+		panic(err)
+	}
+
+	positions := make(map[[3]byte]bool)
+	for _, v := range exported.Voxels {
+		positions[[3]byte{v[0], v[1], v[2]}] = true
+	}
+
+	if len(positions) != len(model.Voxels) {
+		panic(fmt.Errorf("exported %v voxels, expected %v", len(positions), len(model.Voxels)))
+	}
+
+	for _, v := range model.Voxels {
+		if positions[[3]byte{v[0], v[1], v[2]}] == false {
+			panic(fmt.Errorf("voxel %v moved by the round-trip", v))
+		}
+	}
+}
+
+func TestVoxTransform(t *testing.T) {
+	var plain, transformed, plainVox, transformedVox bytes.Buffer
+
+	cfg := testBuildConfig(MipR8G8B8A8UnpackUI32, &plain)
+	cfg.Optimize = false
+	if _, err := BuildTree(&cfg); err != nil {
+		panic(err)
+	}
+
+	cfg = testBuildConfig(MipR8G8B8A8UnpackUI32, &transformed)
+	cfg.Optimize = false
+	cfg.Transform = [16]float64{0, 0, -1, 0, 0, 1, 0, 0, 1, 0, 0, 0, 100, 0, 0, 1}
+	if _, err := BuildTree(&cfg); err != nil {
+		panic(err)
+	}
+
+	if err := ExportVox(bytes.NewReader(plain.Bytes()), &plainVox, -1); err != nil {
+		panic(err)
+	}
+
+	if err := ExportVox(bytes.NewReader(transformed.Bytes()), &transformedVox, -1); err != nil {
+		panic(err)
+	}
+
+	// The vox model stays on the grid of the tree.
+	if bytes.Equal(plainVox.Bytes(), transformedVox.Bytes()) == false {
+		panic("vox export applied the tree transform")
+	}
+}
+
+func TestVoxDefaultPalette(t *testing.T) {
+	var data bytes.Buffer
+
+	fields := []interface{}{
+		voxSignature, uint32(voxVersion),
+		voxChunk{voxMain, 0, 3*voxChunkSize + 12 + 12 + 4},
+		voxChunk{[4]byte{'P', 'A', 'C', 'K'}, 4, 0}, uint32(1),
+		voxChunk{voxSize, 12, 0}, [3]uint32{2, 3, 4},
+		voxChunk{voxXYZI, 12, 0}, uint32(2), [2][4]byte{{1, 2, 3, 1}, {0, 0, 0, 255}},
+	}
+
+	for _, field := range fields {
+		if err := binary.Write(&data, binary.LittleEndian, field); err != nil {
+			panic(err)
+		}
+	}
+
+	model, err := ReadVox(&data)
+	if err != nil {
+		panic(err)
+	}
+
+	pal := model.Palette
+	if pal[0] != [4]byte{} || pal[1] != [4]byte{255, 255, 255, 255} || pal[215] != [4]byte{0, 0, 0x33, 255} ||
+		pal[216] != [4]byte{0xee, 0, 0, 255} || pal[255] != [4]byte{0x11, 0x11, 0x11, 255} {
+		panic("invalid default palette")
+	}
+
+	if model.Size != [3]int{2, 3, 4} || len(model.Voxels) != 2 || model.VoxelsPerAxis() != 4 {
+		panic(fmt.Errorf("unexpected model: %v %v", model.Size, model.Voxels))
+	}
+
+	if _, err := ReadVox(bytes.NewReader(nil)); err == nil {
+		panic("expected error for empty file")
+	}
+}
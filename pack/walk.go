@@ -0,0 +1,130 @@
+/*
+Copyright (C) 2015-2016 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package pack
+
+// walkFunc is called for every node visited by walkTree. Returning false
+// skips the children of the node.
+type walkFunc func(index uint64, bounds Box, depth int, color Color, attr *Attributes, children []uint64) (bool, error)
+
+// walkTree visits the nodes of tree depth first, starting at the root which
+// covers bounds.
+func walkTree(tree *OctreeReader, bounds Box, fn walkFunc) error {
+	if tree.NumNodes() == 0 {
+		return errEmptyTree
+	}
+	return walkNode(tree, 0, bounds, 0, fn)
+}
+
+func walkNode(tree *OctreeReader, index uint64, bounds Box, depth int, fn walkFunc) error {
+	var (
+		color    Color
+		attr     Attributes
+		children [8]uint64
+	)
+
+	// Deeper than any tree can be, there must be a cycle.
+	if depth > 64 {
+		return errTreeLayout
+	}
+
+	if err := tree.ReadNode(index, &color, &attr, children[:]); err != nil {
+		return err
+	}
+
+	descend, err := fn(index, bounds, depth, color, &attr, children[:])
+	if err != nil || descend == false {
+		return err
+	}
+
+	for i, child := range children {
+		if child == 0 {
+			continue
+		}
+
+		if child >= tree.NumNodes() {
+			return errNodeOutOfRange
+		}
+
+		if err := walkNode(tree, child, childBox(bounds, i), depth+1, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func childBox(bounds Box, i int) Box {
+	size := bounds.Size * 0.5
+	offset := childPositions[i].scale(size)
+	return Box{bounds.Pos.add(&offset), size}
+}
+
+func noChildren(children []uint64) bool {
+	for _, child := range children {
+		if child > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// voxelDepth returns the depth of the deepest level of a tree.
+func voxelDepth(voxelsPerAxis uint32) int {
+	depth := 0
+	for n := voxelsPerAxis; n > 1; n /= 2 {
+		depth++
+	}
+	return depth
+}
+
+// gridCell is the position of a voxel in a grid over the bounds of a tree.
+type gridCell [3]int64
+
+// walkCells calls fn for every voxel at depth that is covered by a node of
+// tree, in tree order. Leafs above depth cover all the voxels below them.
+func walkCells(tree *OctreeReader, depth int, fn func(cell gridCell, color Color) error) error {
+	bounds := tree.Header().Bounds
+	cellSize := bounds.Size / float64(int64(1)<<uint(depth))
+
+	return walkTree(tree, bounds, func(index uint64, box Box, d int, color Color, attr *Attributes, children []uint64) (bool, error) {
+		if d < depth && noChildren(children) == false {
+			return true, nil
+		}
+
+		first := gridCell{
+			int64((box.Pos.X-bounds.Pos.X)/cellSize + 0.5),
+			int64((box.Pos.Y-bounds.Pos.Y)/cellSize + 0.5),
+			int64((box.Pos.Z-bounds.Pos.Z)/cellSize + 0.5),
+		}
+
+		n := int64(1)
+		if d < depth {
+			n <<= uint(depth - d)
+		}
+
+		for x := int64(0); x < n; x++ {
+			for y := int64(0); y < n; y++ {
+				for z := int64(0); z < n; z++ {
+					if err := fn(gridCell{first[0] + x, first[1] + y, first[2] + z}, color); err != nil {
+						return false, err
+					}
+				}
+			}
+		}
+		return false, nil
+	})
+}
@@ -0,0 +1,232 @@
+/*
+Copyright (C) 2015-2016 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package pack
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+var trailerSignature = [4]byte{0x1b, 0x65, 0x6e, 0x64}
+
+// octreeTrailer holds the node counts of a tree written to a destination that
+// could not be seeked, it is stored last in the file.
+type octreeTrailer struct {
+	NumNodes uint64
+	NumLeafs uint64
+	Sign     [4]byte
+}
+
+// OctreeWriter writes a tree one node at a time, in index order. The node
+// counts in the header are updated when the writer is closed, either in place
+// if the destination is seekable or in a trailer at the end of the file.
+//
+// Paletted and relative formats must have the palette and far pointers set in
+// the header passed to NewOctreeWriter.
+type OctreeWriter struct {
+	writer     io.Writer
+	nodeWriter io.WriteCloser
+	header     OctreeHeader
+	seeker     io.WriteSeeker
+	start      int64
+	closed     bool
+}
+
+// NewOctreeWriter writes header to writer and returns an OctreeWriter for the
+// nodes that follow. The node data is compressed with codec unless it is nil.
+//
+// A tree written to a writer that can not seek has its node counts in a
+// trailer. It can be written to a pipe, but reading it back needs an
+// io.ReadSeeker since the trailer is read before the nodes.
+func NewOctreeWriter(writer io.Writer, header OctreeHeader, codec Codec) (*OctreeWriter, error) {
+	if int(header.Format) >= len(formatIndexSize) || header.Format == mipR64G64B64A64S64UnpackUI64 {
+		return nil, errUnsupportedFormat
+	}
+
+	if codec == nil {
+		codec = noneCodec{}
+	}
+
+	header.Sign = octreeSignature
+	header.Version = binaryVersion
+	header.NumNodes = 0
+	header.NumLeafs = 0
+	header.Flags &^= compressedMask | blockedMask | trailerMask
+	header.Codec = CodecNone
+	header.BlockSize = 0
+
+	if codec.ID() != CodecNone {
+		header.Codec = codec.ID()
+		header.Flags |= compressedMask
+	}
+
+	w := &OctreeWriter{writer: writer, header: header}
+	if seeker, ok := writer.(io.WriteSeeker); ok == true {
+		if start, err := seeker.Seek(0, 1); err == nil {
+			w.seeker = seeker
+			w.start = start
+		}
+	}
+
+	if w.seeker == nil {
+		w.header.Flags |= trailerMask
+	}
+
+	if err := EncodeHeader(writer, w.header); err != nil {
+		return nil, err
+	}
+
+	nodeWriter, err := codec.NewWriter(writer)
+	if err != nil {
+		return nil, err
+	}
+
+	w.nodeWriter = nodeWriter
+	return w, nil
+}
+
+// Header returns the header with the counts of the nodes written so far.
+func (w *OctreeWriter) Header() OctreeHeader {
+	header := w.header
+	header.Flags &^= trailerMask
+	return header
+}
+
+// WriteNode writes the next node. Children are absolute node indices where
+// zero means no child, a node without children is counted as a leaf.
+func (w *OctreeWriter) WriteNode(color Color, attr *Attributes, children []uint64) error {
+	if err := EncodeNode(w.nodeWriter, &w.header, w.header.NumNodes, color, attr, children); err != nil {
+		return err
+	}
+
+	if noChildren(children) == true {
+		w.header.NumLeafs++
+	}
+	w.header.NumNodes++
+	return nil
+}
+
+// Close flushes the node data and writes the final node counts. It does not
+// close the underlying writer.
+func (w *OctreeWriter) Close() error {
+	if w.closed == true {
+		return nil
+	}
+	w.closed = true
+
+	if err := w.nodeWriter.Close(); err != nil {
+		return err
+	}
+
+	if w.seeker == nil {
+		trailer := octreeTrailer{w.header.NumNodes, w.header.NumLeafs, trailerSignature}
+		return binary.Write(w.writer, w.header.ByteOrder(), &trailer)
+	}
+
+	end, err := w.seeker.Seek(0, 1)
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.seeker.Seek(w.start, 0); err != nil {
+		return err
+	}
+
+	if err := EncodeHeader(w.seeker, w.header); err != nil {
+		return err
+	}
+
+	_, err = w.seeker.Seek(end, 0)
+	return err
+}
+
+// decodeTrailer reads the node counts from the end of reader and restores the
+// position, clearing the trailer flag of header.
+func decodeTrailer(reader io.Reader, header *OctreeHeader) error {
+	seeker, ok := reader.(io.ReadSeeker)
+	if ok == false {
+		return errTrailerSeek
+	}
+
+	pos, err := seeker.Seek(0, 1)
+	if err != nil {
+		return errTrailer
+	}
+
+	var trailer octreeTrailer
+	if _, err := seeker.Seek(-int64(binary.Size(trailer)), 2); err != nil {
+		return errTrailer
+	}
+
+	if err := binary.Read(seeker, header.ByteOrder(), &trailer); err != nil {
+		return err
+	}
+
+	if trailer.Sign != trailerSignature {
+		return errTrailer
+	}
+
+	if _, err := seeker.Seek(pos, 0); err != nil {
+		return err
+	}
+
+	header.NumNodes = trailer.NumNodes
+	header.NumLeafs = trailer.NumLeafs
+	header.Flags &^= trailerMask
+	return nil
+}
+
+// hasTrailer reports whether the tree at the position of seeker keeps its
+// node counts in a trailer. The position is restored.
+func hasTrailer(seeker io.ReadSeeker) (bool, error) {
+	pos, err := seeker.Seek(0, 1)
+	if err != nil {
+		return false, err
+	}
+
+	var header OctreeHeader
+	for _, field := range header.baseFields() {
+		if err := binary.Read(seeker, header.ByteOrder(), field); err != nil {
+			return false, err
+		}
+	}
+
+	if _, err := seeker.Seek(pos, 0); err != nil {
+		return false, err
+	}
+	return header.Flags&trailerMask == trailerMask, nil
+}
+
+// trailerDataSize returns the number of bytes from the position of seeker to
+// the trailer. The position is restored.
+func trailerDataSize(seeker io.ReadSeeker) (int64, error) {
+	pos, err := seeker.Seek(0, 1)
+	if err != nil {
+		return 0, err
+	}
+
+	end, err := seeker.Seek(-int64(binary.Size(octreeTrailer{})), 2)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := seeker.Seek(pos, 0); err != nil {
+		return 0, err
+	}
+	return end - pos, nil
+}
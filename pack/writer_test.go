@@ -0,0 +1,185 @@
+/*
+Copyright (C) 2015-2016 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package pack
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func writeTestTree(writer io.Writer, tree *OctreeReader, codec Codec) {
+	var (
+		color    Color
+		children [8]uint64
+	)
+
+	w, err := NewOctreeWriter(writer, tree.Header(), codec)
+	if err != nil {
+		panic(err)
+	}
+
+	for i := uint64(0); i < tree.NumNodes(); i++ {
+		if err := tree.ReadNode(i, &color, nil, children[:]); err != nil {
+			panic(err)
+		}
+		if err := w.WriteNode(color, nil, children[:]); err != nil {
+			panic(err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+}
+
+func TestOctreeWriter(t *testing.T) {
+	for _, format := range []OctreeFormat{MipR8G8B8A8UnpackUI32, MipR5G6B5PackUI30, MipP8PackUI31, MipR8G8B8A8PackRelUI28} {
+		data := buildTestTree(format)
+		header, colors, children := decodeTestTree(data)
+
+		tree, err := NewOctreeReader(bytes.NewReader(data))
+		if err != nil {
+			panic(err)
+		}
+
+		for _, codec := range []Codec{nil, NewZlibCodec(-1)} {
+			fp, err := ioutil.TempFile("", "")
+			if err != nil {
+				panic(err)
+			}
+
+			var buffer bytes.Buffer
+			writeTestTree(fp, tree, codec)
+			writeTestTree(&buffer, tree, codec)
+
+			if _, err := fp.Seek(0, 0); err != nil {
+				panic(err)
+			}
+
+			stream, err := ioutil.ReadAll(fp)
+			removeTempFile(fp)
+			if err != nil {
+				panic(err)
+			}
+
+			for _, output := range [][]byte{stream, buffer.Bytes()} {
+				var plain bytes.Buffer
+				if codec != nil {
+					if err := DecompressTree(bytes.NewReader(output), &plain); err != nil {
+						panic(err)
+					}
+					output = plain.Bytes()
+				}
+
+				h, c, ch := decodeTestTree(output)
+				if h.NumNodes != header.NumNodes || h.NumLeafs != header.NumLeafs {
+					panic(fmt.Errorf("node counts mismatch in format %v", format))
+				}
+
+				for i := range colors {
+					if c[i] != colors[i] || ch[i] != children[i] {
+						panic(fmt.Errorf("node %v mismatch in format %v", i, format))
+					}
+				}
+
+				report, err := Validate(bytes.NewReader(output))
+				if err != nil {
+					panic(err)
+				}
+				if report.Valid() == false {
+					panic(fmt.Errorf("invalid tree in format %v: %+v", format, report))
+				}
+			}
+		}
+		tree.Close()
+	}
+}
+
+func TestOctreeWriterTrailer(t *testing.T) {
+	var buffer bytes.Buffer
+	w, err := NewOctreeWriter(&buffer, OctreeHeader{Format: MipR8G8B8A8UnpackUI32, VoxelsPerAxis: 1}, nil)
+	if err != nil {
+		panic(err)
+	}
+	if err := w.WriteNode(Color{1, 0, 0, 1}, nil, make([]uint64, 8)); err != nil {
+		panic(err)
+	}
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+
+	var header OctreeHeader
+	if err := DecodeHeader(bytes.NewBuffer(buffer.Bytes()), &header); err != errTrailerSeek {
+		panic("expected errTrailerSeek")
+	}
+
+	if err := DecodeHeader(bytes.NewReader(buffer.Bytes()), &header); err != nil {
+		panic(err)
+	}
+	if header.NumNodes != 1 || header.NumLeafs != 1 || header.Flags != 0 {
+		panic("invalid trailer")
+	}
+}
+
+func TestOctreeWriterTrailerRelative(t *testing.T) {
+	var buffer bytes.Buffer
+
+	header := OctreeHeader{Format: MipR8G8B8A8PackRelUI28, VoxelsPerAxis: 2, FarPointers: []uint64{1, 2}}
+	w, err := NewOctreeWriter(&buffer, header, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	nodes := [][8]uint64{{1, 2}, {}, {}}
+	for i, children := range nodes {
+		if err := w.WriteNode(Color{float32(i % 2), 0, 0, 1}, nil, children[:]); err != nil {
+			panic(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+
+	tree, err := NewOctreeReader(bytes.NewReader(buffer.Bytes()))
+	if err != nil {
+		panic(err)
+	}
+	defer tree.Close()
+
+	h := tree.Header()
+	if h.NumNodes != 3 || h.NumLeafs != 2 || len(h.FarPointers) != 2 {
+		panic(fmt.Errorf("invalid relative trailer header: %+v", h))
+	}
+
+	var (
+		color    Color
+		children [8]uint64
+	)
+
+	for i, expected := range nodes {
+		if err := tree.ReadNode(uint64(i), &color, nil, children[:]); err != nil {
+			panic(err)
+		}
+		if children != expected || color.R != float32(i%2) {
+			panic(fmt.Errorf("node %v mismatch in relative trailer tree", i))
+		}
+	}
+}
@@ -0,0 +1,130 @@
+/*
+Copyright (C) 2015-2016 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package trace
+
+import (
+	"encoding/binary"
+	"os"
+	"reflect"
+	"sync"
+	"unsafe"
+
+	"github.com/andreas-jonsson/octatron/pack"
+)
+
+var mappedTrees = struct {
+	sync.Mutex
+	data map[*octreeNode][]byte
+}{data: make(map[*octreeNode][]byte)}
+
+func nativeByteOrder() binary.ByteOrder {
+	var word uint16 = 1
+	if *(*byte)(unsafe.Pointer(&word)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}
+
+// MapOctree is like LoadOctree but uses the file directly as the tree when it
+// is uncompressed MipR8G8B8A8PackUI28 in native byte order and the platform
+// supports memory mapping. Other trees are decoded with LoadOctree.
+// Mapped trees are read-only and should be released with UnmapOctree.
+func MapOctree(fp *os.File) (Octree, int, pack.Box, error) {
+	var header pack.OctreeHeader
+	if err := pack.DecodeHeader(fp, &header); err != nil {
+		return nil, 0, pack.Box{}, err
+	}
+
+	if tree, err := mapTree(fp, &header); err == nil {
+		return tree, int(header.VoxelsPerAxis), header.Bounds, nil
+	} else if err == InvalidChildError {
+		return nil, 0, pack.Box{}, err
+	}
+
+	if _, err := fp.Seek(0, 0); err != nil {
+		return nil, 0, pack.Box{}, err
+	}
+	return LoadOctree(fp)
+}
+
+func mapTree(fp *os.File, header *pack.OctreeHeader) (Octree, error) {
+	const nodeSize = int(unsafe.Sizeof(octreeNode{}))
+
+	if header.Format != pack.MipR8G8B8A8PackUI28 || header.Compressed() || header.Channels != 0 {
+		return nil, NotMappableError
+	}
+
+	offset := header.Size()
+	if header.ByteOrder() != nativeByteOrder() || offset%4 != 0 || header.NumNodes == 0 {
+		return nil, NotMappableError
+	}
+
+	info, err := fp.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	size := uint64(offset) + header.NumNodes*uint64(nodeSize)
+	if size > uint64(info.Size()) || size != uint64(int(size)) {
+		return nil, NotMappableError
+	}
+
+	data, err := mapFile(fp, int(size))
+	if err != nil {
+		return nil, err
+	}
+
+	var tree Octree
+	slice := (*reflect.SliceHeader)(unsafe.Pointer(&tree))
+	slice.Data = uintptr(unsafe.Pointer(&data[offset]))
+	slice.Len = int(header.NumNodes)
+	slice.Cap = int(header.NumNodes)
+
+	for i := range tree {
+		n := &tree[i]
+		for j := range n {
+			if uint64(n.getChild(j)) >= header.NumNodes {
+				unmapFile(data)
+				return nil, InvalidChildError
+			}
+		}
+	}
+
+	mappedTrees.Lock()
+	mappedTrees.data[&tree[0]] = data
+	mappedTrees.Unlock()
+	return tree, nil
+}
+
+// UnmapOctree releases a tree returned by MapOctree. The tree must not be
+// used afterwards. Decoded trees are left to the garbage collector.
+func UnmapOctree(tree Octree) error {
+	if len(tree) == 0 {
+		return nil
+	}
+
+	mappedTrees.Lock()
+	data, ok := mappedTrees.data[&tree[0]]
+	delete(mappedTrees.data, &tree[0])
+	mappedTrees.Unlock()
+
+	if !ok {
+		return nil
+	}
+	return unmapFile(data)
+}
@@ -0,0 +1,127 @@
+// +build linux
+
+/*
+Copyright (C) 2015-2016 Andreas T Jonsson
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package trace
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andreas-jonsson/octatron/pack"
+)
+
+func testWorker(samples chan<- pack.Sample) error {
+	for i := 0; i < 8; i++ {
+		f := float32(i) / 8
+		samples <- pack.Sample{Pos: pack.Point{float64(i)*10 + 5, float64(i%3)*20 + 5, float64(i%5)*15 + 5}, Col: pack.Color{f, 1 - f, f, 1}}
+	}
+	return nil
+}
+
+func TestMapOctree(t *testing.T) {
+	fp, err := ioutil.TempFile("", "")
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove(fp.Name())
+	defer fp.Close()
+
+	// Packer stores the source, time and command line, so the header size
+	// depends on the input.
+	for n := 1; n <= 8; n++ {
+		meta := pack.Metadata{
+			"source":  strings.Repeat("a", n) + ".xyz",
+			"created": time.Now().UTC().Format(time.RFC3339),
+			"packer":  "-input " + strings.Repeat("a", n) + ".xyz",
+		}
+
+		if err := fp.Truncate(0); err != nil {
+			panic(err)
+		}
+
+		if _, err := fp.Seek(0, 0); err != nil {
+			panic(err)
+		}
+
+		cfg := pack.BuildConfig{
+			Worker:        testWorker,
+			Writer:        fp,
+			Bounds:        pack.Box{pack.Point{0, 0, 0}, 80},
+			VoxelsPerAxis: 8,
+			Format:        pack.MipR8G8B8A8PackUI28,
+			ByteOrder:     nativeByteOrder(),
+			Metadata:      meta,
+		}
+
+		if _, err := pack.BuildTree(&cfg); err != nil {
+			panic(err)
+		}
+
+		if _, err := fp.Seek(0, 0); err != nil {
+			panic(err)
+		}
+
+		tree, _, _, err := MapOctree(fp)
+		if err != nil {
+			panic(err)
+		}
+
+		mappedTrees.Lock()
+		_, mapped := mappedTrees.data[&tree[0]]
+		mappedTrees.Unlock()
+
+		if !mapped {
+			panic(fmt.Errorf("tree with a %v byte source name was not mapped", n))
+		}
+
+		if _, err := fp.Seek(0, 0); err != nil {
+			panic(err)
+		}
+
+		loaded, _, _, err := LoadOctree(fp)
+		if err != nil {
+			panic(err)
+		}
+
+		if len(tree) != len(loaded) {
+			panic("mapped tree differs from the loaded tree")
+		}
+
+		// LoadOctree does not keep the unused alpha nibbles.
+		for i := range tree {
+			if tree[i].getColor() != loaded[i].getColor() {
+				panic(fmt.Errorf("node %v has a different color when mapped", i))
+			}
+
+			for j := range tree[i] {
+				if tree[i].getChild(j) != loaded[i].getChild(j) {
+					panic(fmt.Errorf("node %v has a different child when mapped", i))
+				}
+			}
+		}
+
+		if err := UnmapOctree(tree); err != nil {
+			panic(err)
+		}
+	}
+}
@@ -71,6 +71,8 @@ type (
 var (
 	InvalidSizeError    = errors.New("invalid size")
 	Uint28OverflowError = errors.New("uint28 overflow")
+	InvalidChildError   = errors.New("child index out of range")
+	NotMappableError    = errors.New("tree can not be memory mapped")
 )
 
 type (
@@ -206,28 +208,53 @@ func TreeWidthToDepth(width int) int {
 	return d
 }
 
-func LoadOctree(reader io.Reader) (Octree, int, error) {
+// LoadOctree decodes a tree and returns it together with its voxels per axis
+// and world-space bounds. Trees written before version 1 of the file format
+// do not record their bounds and will report a zero sized box.
+func LoadOctree(reader io.Reader) (Octree, int, pack.Box, error) {
 	var (
 		color  pack.Color
 		header pack.OctreeHeader
 	)
 
 	if err := pack.DecodeHeader(reader, &header); err != nil {
-		return nil, 0, err
+		return nil, 0, pack.Box{}, err
 	}
 
+	nodeReader, err := pack.NewNodeReader(reader, &header)
+	if err != nil {
+		return nil, 0, pack.Box{}, err
+	}
+	defer nodeReader.Close()
+
+	var children [8]uint64
+
 	data := make([]octreeNode, header.NumNodes)
 	for i := range data {
 		n := &data[i]
-		if err := pack.DecodeNode(reader, header.Format, &color, n[:]); err != nil {
-			return nil, 0, err
+		if err := pack.DecodeNode(nodeReader, &header, uint64(i), &color, nil, children[:]); err != nil {
+			return nil, 0, pack.Box{}, err
+		}
+
+		for j, child := range children {
+			if child > maxUint28 {
+				return nil, 0, pack.Box{}, Uint28OverflowError
+			}
+			n[j] = uint32(child)
 		}
+
 		if err := n.setColor(&color); err != nil {
-			return nil, 0, err
+			return nil, 0, pack.Box{}, err
+		}
+
+		for j := range n {
+			if uint64(n.getChild(j)) >= header.NumNodes {
+				return nil, 0, pack.Box{}, InvalidChildError
+			}
 		}
 	}
 
-	return data, int(header.VoxelsPerAxis), nil
+	return data, int(header.VoxelsPerAxis), header.Bounds, nil
 }
 
 func Reconstruct(a, b image.Image, out draw.Image) error {